@@ -1,8 +1,10 @@
 package apix
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
+	"io/fs"
 	"net/http"
 
 	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
@@ -16,43 +18,52 @@ func init() {
 	DefaultService = New()
 }
 
-func ListenAndServe(addr string) error { return DefaultService.ListenAndServe(addr) }
+func ListenAndServe(addr string) error   { return DefaultService.ListenAndServe(addr) }
+func Shutdown(ctx context.Context) error { return DefaultService.Shutdown(ctx) }
 
-func ANY(path string, h any)            { DefaultService.ANY(path, h) }
-func GET(path string, h any)            { DefaultService.GET(path, h) }
-func POST(path string, h any)           { DefaultService.POST(path, h) }
-func PUT(path string, h any)            { DefaultService.PUT(path, h) }
-func PATCH(path string, h any)          { DefaultService.PATCH(path, h) }
-func DELETE(path string, h any)         { DefaultService.DELETE(path, h) }
-func TRACE(path string, h any)          { DefaultService.TRACE(path, h) }
-func HEAD(path string, h any)           { DefaultService.HEAD(path, h) }
-func OPTION(path string, h any)         { DefaultService.OPTION(path, h) }
-func CONNECT(path string, h any)        { DefaultService.CONNECT(path, h) }
-func GRPCGatewayMux() *runtime.ServeMux { return DefaultService.GRPCGatewayMux() }
+func ANY(path string, h any, opts ...RouteOption)     { DefaultService.ANY(path, h, opts...) }
+func GET(path string, h any, opts ...RouteOption)     { DefaultService.GET(path, h, opts...) }
+func POST(path string, h any, opts ...RouteOption)    { DefaultService.POST(path, h, opts...) }
+func PUT(path string, h any, opts ...RouteOption)     { DefaultService.PUT(path, h, opts...) }
+func PATCH(path string, h any, opts ...RouteOption)   { DefaultService.PATCH(path, h, opts...) }
+func DELETE(path string, h any, opts ...RouteOption)  { DefaultService.DELETE(path, h, opts...) }
+func TRACE(path string, h any, opts ...RouteOption)   { DefaultService.TRACE(path, h, opts...) }
+func HEAD(path string, h any, opts ...RouteOption)    { DefaultService.HEAD(path, h, opts...) }
+func OPTION(path string, h any, opts ...RouteOption)  { DefaultService.OPTION(path, h, opts...) }
+func CONNECT(path string, h any, opts ...RouteOption) { DefaultService.CONNECT(path, h, opts...) }
+func GRPCGatewayMux() *runtime.ServeMux               { return DefaultService.GRPCGatewayMux() }
+func Static(prefix string, fsys fs.FS, opts StaticOptions, routeOpts ...RouteOption) {
+	DefaultService.Static(prefix, fsys, opts, routeOpts...)
+}
+func Proxy(pattern string, targets []string, opts ProxyOptions, routeOpts ...RouteOption) {
+	DefaultService.Proxy(pattern, targets, opts, routeOpts...)
+}
 func GROUP(path string, middlewares ...Middleware) *Group {
 	return DefaultService.GROUP(path, middlewares...)
 }
 
 // Return write the result and code into ResponseWriter
 func Return(w http.ResponseWriter, status int, data any, marshaler func(any) ([]byte, error)) {
-	var (
-		content []byte
-		err     error
-	)
-	if data != nil {
-		content, err = marshaler(data)
-		if err != nil {
-			content, _ = json.Marshal(ResponseBody{
-				Code:    1,
-				Message: err.Error(),
-			})
-		}
-	}
 	if status <= 0 {
 		status = 200
 	}
 	w.WriteHeader(status)
-	w.Write(content)
+	w.Write(marshalContent(data, marshaler))
+}
+
+// marshalContent marshal the data by marshaler, the error will be marshaled as ResponseBody if marshaling failed
+func marshalContent(data any, marshaler func(any) ([]byte, error)) []byte {
+	if data == nil {
+		return nil
+	}
+	content, err := marshaler(data)
+	if err != nil {
+		content, _ = json.Marshal(ResponseBody{
+			Code:    1,
+			Message: err.Error(),
+		})
+	}
+	return content
 }
 
 func ReturnJSON(w http.ResponseWriter, status int, data any) {
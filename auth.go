@@ -0,0 +1,174 @@
+package apix
+
+import (
+	"context"
+	"errors"
+)
+
+var (
+	// ErrUnauthenticated is returned when the route requires authorization but no Principal found in request
+	ErrUnauthenticated = errors.New("unauthenticated")
+	// ErrForbidden is returned when the Principal do not have the required scopes or roles
+	ErrForbidden = errors.New("permission denied")
+)
+
+// Principal represents the authenticated caller of request, the authentication middleware should set it into request by WithPrincipal
+type Principal struct {
+	ID     string
+	Roles  []string
+	Scopes []string
+	Claims map[string]any
+}
+
+// HasRole report whether the principal has the given role
+func (p *Principal) HasRole(role string) bool {
+	if p == nil {
+		return false
+	}
+	for _, r := range p.Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// HasScope report whether the principal has the given scope, the scopes of principal can be wildcard pattern, e.g: "orders:*"
+func (p *Principal) HasScope(scope string) bool {
+	if p == nil {
+		return false
+	}
+	for _, s := range p.Scopes {
+		if matchStr(s, scope) {
+			return true
+		}
+	}
+	return false
+}
+
+// WithPrincipal add the principal into given ctx, and return the new context.
+// Use it in authentication middleware: next(w, r.WithContext(apix.WithPrincipal(r.Context(), p)))
+func WithPrincipal(ctx context.Context, p *Principal) context.Context {
+	return context.WithValue(ctx, principalKey, p)
+}
+
+// PrincipalFrom peek the *apix.Principal from given context, it return nil if not exist
+func PrincipalFrom(ctx context.Context) *Principal {
+	p, _ := ctx.Value(principalKey).(*Principal)
+	return p
+}
+
+// Policy decides whether the request is allowed to access the route, it's evaluated before handler binding.
+// Returning a non-nil error means the request is forbidden, the error will be responsed with 403 status code unless it's a ResponseBody.
+type Policy interface {
+	Authorize(ctx *Context, route *Route) error
+}
+
+// PolicyFunc is a function adapter of Policy
+type PolicyFunc func(ctx *Context, route *Route) error
+
+// Authorize implements the Policy interface
+func (f PolicyFunc) Authorize(ctx *Context, route *Route) error { return f(ctx, route) }
+
+// RequireScopes requires the request principal having all of the scopes, it can be used on route registering or Group.With
+func RequireScopes(scopes ...string) RouteOption {
+	return func(r *Route) {
+		r.Scopes = append(r.Scopes, scopes...)
+	}
+}
+
+// RequireRoles requires the request principal having one of the roles
+func RequireRoles(roles ...string) RouteOption {
+	return func(r *Route) {
+		r.Roles = append(r.Roles, roles...)
+	}
+}
+
+// RequirePolicy requires the request passing the policies
+func RequirePolicy(policies ...Policy) RouteOption {
+	return func(r *Route) {
+		r.policies = append(r.policies, policies...)
+	}
+}
+
+// WithPolicy specifics policies evaluated on all the service routes, e.g: a in-process OPA rule evaluation.
+// The health probes registered by WithHealthChecks are not evaluated.
+func WithPolicy(policies ...Policy) ServiceOption {
+	return func(srv *Service) {
+		srv.policies = append(srv.policies, policies...)
+	}
+}
+
+// WithRoleScopes specifics the scopes granted by each role (RBAC), a principal owns the scopes of its roles in addition to Principal.Scopes
+func WithRoleScopes(roleScopes map[string][]string) ServiceOption {
+	return func(srv *Service) {
+		srv.roleScopes = roleScopes
+	}
+}
+
+// Principal return the authenticated principal of request, it return nil if not exist
+func (c *Context) Principal() *Principal {
+	return PrincipalFrom(c.Request.Context())
+}
+
+// authorize check the route's requirement on the request, it return a ResponseBody error with 401 or 403 code if not allowed
+func (srv *Service) authorize(ctx *Context, route *Route) error {
+	globals := srv.policies
+	if route.internal {
+		globals = nil
+	}
+	if len(route.Scopes) == 0 && len(route.Roles) == 0 && len(route.policies) == 0 && len(globals) == 0 {
+		return nil
+	}
+	p := ctx.Principal()
+	if len(route.Scopes) > 0 || len(route.Roles) > 0 {
+		if p == nil {
+			return ResponseBody{Code: 401, Message: ErrUnauthenticated.Error()}
+		}
+		for _, scope := range route.Scopes {
+			if !srv.hasScope(p, scope) {
+				return ResponseBody{Code: 403, Message: ErrForbidden.Error() + ": scope " + scope + " required"}
+			}
+		}
+		if len(route.Roles) > 0 {
+			allowed := false
+			for _, role := range route.Roles {
+				if p.HasRole(role) {
+					allowed = true
+					break
+				}
+			}
+			if !allowed {
+				return ResponseBody{Code: 403, Message: ErrForbidden.Error()}
+			}
+		}
+	}
+	for _, policies := range [][]Policy{globals, route.policies} {
+		for _, policy := range policies {
+			if err := policy.Authorize(ctx, route); err != nil {
+				if rb, ok := err.(ResponseBody); ok {
+					return rb
+				}
+				if errors.Is(err, ErrUnauthenticated) {
+					return ResponseBody{Code: 401, Message: err.Error()}
+				}
+				return ResponseBody{Code: 403, Message: err.Error()}
+			}
+		}
+	}
+	return nil
+}
+
+func (srv *Service) hasScope(p *Principal, scope string) bool {
+	if p.HasScope(scope) {
+		return true
+	}
+	for _, role := range p.Roles {
+		for _, s := range srv.roleScopes[role] {
+			if matchStr(s, scope) {
+				return true
+			}
+		}
+	}
+	return false
+}
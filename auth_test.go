@@ -0,0 +1,54 @@
+package apix
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+)
+
+type authHello struct{}
+
+func (h *authHello) Execute(ctx *Context) (any, error) { return "hello", nil }
+
+func TestAuthorize(t *testing.T) {
+	denyAnonymous := PolicyFunc(func(ctx *Context, route *Route) error {
+		if ctx.Principal() == nil {
+			return ErrUnauthenticated
+		}
+		return nil
+	})
+	srv := New(WithPolicy(denyAnonymous), WithHealthChecks(), WithRoleScopes(map[string][]string{"admin": {"orders:*"}}))
+	srv.GET("/hello", &authHello{})
+	srv.POST("/orders", &authHello{}, RequireScopes("orders:write"))
+	srv.DELETE("/orders", &authHello{}, RequireRoles("admin", "owner"))
+	tests := []struct {
+		method, target string
+		principal      *Principal
+		status         int
+		code           int
+	}{
+		{"GET", "/hello", nil, 401, 401},
+		{"GET", "/hello", &Principal{ID: "u"}, 200, 0},
+		{"GET", "/livez", nil, 200, 0},
+		{"GET", "/readyz", nil, 200, 0},
+		{"GET", "/healthz", nil, 200, 0},
+		{"POST", "/orders", &Principal{ID: "u"}, 403, 403},
+		{"POST", "/orders", &Principal{ID: "u", Scopes: []string{"orders:*"}}, 200, 0},
+		{"POST", "/orders", &Principal{ID: "u", Roles: []string{"admin"}}, 200, 0},
+		{"DELETE", "/orders", &Principal{ID: "u", Roles: []string{"owner"}}, 200, 0},
+		{"DELETE", "/orders", &Principal{ID: "u", Roles: []string{"guest"}}, 403, 403},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(tt.method, tt.target, nil)
+		if tt.principal != nil {
+			r = r.WithContext(WithPrincipal(r.Context(), tt.principal))
+		}
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, r)
+		var body struct{ Code int }
+		_ = json.Unmarshal(w.Body.Bytes(), &body)
+		if w.Code != tt.status || body.Code != tt.code {
+			t.Errorf("%s %s %v: got %d %s, want %d code %d", tt.method, tt.target, tt.principal, w.Code, w.Body.String(), tt.status, tt.code)
+		}
+	}
+}
@@ -0,0 +1,161 @@
+package apix
+
+import (
+	"reflect"
+
+	"github.com/bytedance/go-tagexpr/v2/binding"
+)
+
+// Binder binds the request parameters into v(pointer of the handler struct), and validates them.
+type Binder interface {
+	Bind(ctx *Context, v any) error
+}
+
+// BinderFunc is a function adapter of Binder
+type BinderFunc func(ctx *Context, v any) error
+
+// Bind implements the Binder interface
+func (f BinderFunc) Bind(ctx *Context, v any) error { return f(ctx, v) }
+
+// Normalizer can be implemented by the handler struct, Normalize will be called after the parameters bound,
+// e.g: trimming strings, filling the default values depend on other fields.
+type Normalizer interface {
+	Normalize(ctx *Context) error
+}
+
+// paramsNormalizer is implemented by the embeddable parameters, e.g: OffsetPagination, CursorPagination and Filtering.
+// They are normalized before the Normalizer of handler, so that they can be embedded together.
+type paramsNormalizer interface {
+	normalizeParams(ctx *Context) error
+}
+
+// BindHook is called after binding and Normalizer, the returned error will be responsed with 400 status code
+type BindHook func(ctx *Context, v any) error
+
+// StructValidator validates the struct by its tags, *validator.Validate in github.com/go-playground/validator implements it
+type StructValidator interface {
+	Struct(s any) error
+}
+
+type tagExprBinder struct {
+	b        *binding.Binding
+	validate bool
+}
+
+// NewTagExprBinder create the Binder by github.com/bytedance/go-tagexpr/v2/binding, which is the default Binder.
+// Parameters are bound by the path, query, header, cookie, form and json tags, and validated by vd tag.
+func NewTagExprBinder(config *binding.Config) Binder {
+	return &tagExprBinder{b: binding.New(config), validate: true}
+}
+
+func (b *tagExprBinder) Bind(ctx *Context, v any) error {
+	params := pathParams{req: ctx.Request}
+	if ctx.route != nil {
+		params.wildcards = ctx.route.wildcards
+	}
+	if b.validate {
+		return b.b.BindAndValidate(v, ctx.Request, params)
+	}
+	return b.b.Bind(v, ctx.Request, params)
+}
+
+type validatorBinder struct {
+	binder    *tagExprBinder
+	validator StructValidator
+}
+
+// NewValidatorBinder create the Binder binding parameters by go-tagexpr(vd tag is ignored), and validating by the validator-style tags.
+//
+//	apix.New(apix.WithBinder(apix.NewValidatorBinder(validator.New())))
+func NewValidatorBinder(v StructValidator) Binder {
+	return &validatorBinder{
+		binder:    &tagExprBinder{b: binding.New(nil)},
+		validator: v,
+	}
+}
+
+func (b *validatorBinder) Bind(ctx *Context, v any) error {
+	if err := b.binder.Bind(ctx, v); err != nil {
+		return err
+	}
+	return b.validator.Struct(v)
+}
+
+// WithBinder specifics the Binder for all the service handlers, the go-tagexpr binder is used by default
+func WithBinder(b Binder) ServiceOption {
+	return func(srv *Service) {
+		srv.binder = b
+	}
+}
+
+// WithBindHooks specifics hooks called after binding for all the service handlers
+func WithBindHooks(hooks ...BindHook) ServiceOption {
+	return func(srv *Service) {
+		srv.bindHooks = append(srv.bindHooks, hooks...)
+	}
+}
+
+// BindWith overrides the Binder on the route
+func BindWith(b Binder) RouteOption {
+	return func(r *Route) {
+		r.binder = b
+	}
+}
+
+// bind binds the parameters into v by the route or service binder, then normalizes it
+func (srv *Service) bind(ctx *Context, route *Route, v any) error {
+	binder := srv.binder
+	if route.binder != nil {
+		binder = route.binder
+	}
+	if err := binder.Bind(ctx, v); err != nil {
+		return err
+	}
+	if err := normalizeEmbedded(ctx, reflect.ValueOf(v)); err != nil {
+		return err
+	}
+	if n, ok := v.(Normalizer); ok {
+		if err := n.Normalize(ctx); err != nil {
+			return err
+		}
+	}
+	for _, hook := range srv.bindHooks {
+		if err := hook(ctx, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// normalizeEmbedded normalizes the embedded parameters of the struct pointed by v recursively
+func normalizeEmbedded(ctx *Context, v reflect.Value) error {
+	if v.Kind() != reflect.Pointer || v.IsNil() || v.Elem().Kind() != reflect.Struct {
+		return nil
+	}
+	v = v.Elem()
+	for i := 0; i < v.NumField(); i++ {
+		if !v.Type().Field(i).Anonymous {
+			continue
+		}
+		field := v.Field(i)
+		if field.Kind() != reflect.Pointer {
+			if !field.CanAddr() {
+				continue
+			}
+			field = field.Addr()
+		}
+		if field.IsNil() || !field.CanInterface() {
+			continue
+		}
+		if n, ok := field.Interface().(paramsNormalizer); ok {
+			if err := n.normalizeParams(ctx); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := normalizeEmbedded(ctx, field); err != nil {
+			return err
+		}
+	}
+	return nil
+}
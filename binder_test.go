@@ -0,0 +1,97 @@
+package apix
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+type bindAudit struct {
+	Actor string `query:"actor"`
+}
+
+type bindUser struct {
+	ID        int    `path:"id"`
+	Path      string `path:"path"`
+	Name      string
+	Filtering string `query:"filtering"`
+	bindAudit
+	OffsetPagination
+	Filtering2 Filtering[partialUser]
+}
+
+func (h *bindUser) Execute(ctx *Context) (any, error) {
+	return map[string]any{
+		"id": h.ID, "path": h.Path, "name": h.Name, "filtering": h.Filtering, "actor": h.Actor,
+		"page": h.Page, "size": h.Size, "filter": h.Filtering2.Filter,
+	}, nil
+}
+
+type bindBoth struct {
+	Filtering[partialUser]
+	CursorPagination
+	normalized bool
+}
+
+func (h *bindBoth) Normalize(ctx *Context) error {
+	h.normalized = true
+	return nil
+}
+
+func (h *bindBoth) Execute(ctx *Context) (any, error) {
+	return map[string]any{"filtered": h.Expr() != nil, "size": h.Size, "normalized": h.normalized}, nil
+}
+
+func TestBind(t *testing.T) {
+	srv := New()
+	srv.GET("/users/{id}/files/{path...}", &bindUser{})
+	srv.GET("/both", &bindBoth{})
+	tests := []struct {
+		target string
+		want   map[string]any
+	}{
+		{"/users/7/files/a/b.txt?Name=bob&filtering=x&actor=alice&page=2&size=5", map[string]any{
+			"id": 7.0, "path": "a/b.txt", "name": "bob", "filtering": "x", "actor": "alice", "page": 2.0, "size": 5.0, "filter": "",
+		}},
+		{"/users/7/files/a?page=0&size=1000", map[string]any{
+			"id": 7.0, "path": "a", "name": "", "filtering": "", "actor": "", "page": 1.0, "size": 100.0, "filter": "",
+		}},
+		{`/both?filter=name="a"&size=3`, map[string]any{"filtered": true, "size": 3.0, "normalized": true}},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, httptest.NewRequest("GET", tt.target, nil))
+		var body struct {
+			Code    int
+			Message string
+			Data    map[string]any
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil || body.Code != 0 {
+			t.Errorf("%s: got %s", tt.target, w.Body.String())
+			continue
+		}
+		if !reflect.DeepEqual(body.Data, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.target, body.Data, tt.want)
+		}
+	}
+}
+
+func TestPatternWildcards(t *testing.T) {
+	tests := map[string][]string{
+		"/users":                            nil,
+		"GET /users/{id}":                   {"id"},
+		"example.com/{a}/{b...}":            {"a", "b"},
+		"/users/{$}":                        nil,
+		"POST /orgs/{org}/users/{user}/{$}": {"org", "user"},
+	}
+	for pattern, names := range tests {
+		want := make(map[string]bool)
+		for _, name := range names {
+			want[name] = true
+		}
+		if got := patternWildcards(pattern); !reflect.DeepEqual(got, want) {
+			t.Errorf("patternWildcards(%q) = %v, want %v", pattern, got, want)
+		}
+	}
+}
@@ -0,0 +1,456 @@
+package apix
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	defaultCacheMaxEntries = 10000
+	defaultCacheMaxBytes   = 64 << 20
+)
+
+// CacheConfig configures the server-side response cache of a route
+type CacheConfig struct {
+	// TTL is the duration the cached response is fresh
+	TTL time.Duration
+	// StaleWhileRevalidate is the duration the stale response can still be served after TTL, while it's revalidated in background
+	StaleWhileRevalidate time.Duration
+	// Query is the query parameters used as cache key, all the query parameters are used if it's empty
+	Query []string
+	// Headers is the request headers used as cache key
+	Headers []string
+	// PerPrincipal caches the response for each request principal separately
+	PerPrincipal bool
+	// Tags is the tags of all the cached responses of the route, which can be invalidated by Context.InvalidateCache
+	Tags []string
+}
+
+// WithCache caches the successful responses of GET requests on the route, the concurrent missing requests with the same key are collapsed into one.
+// Only the responses with 200 status and without error are cached, and Set-Cookie responses are never cached.
+func WithCache(cfg CacheConfig) RouteOption {
+	return func(r *Route) {
+		r.cache = &cfg
+	}
+}
+
+// WithCacheStore specifics the store of response cache, an in-memory LRU store is used by default
+func WithCacheStore(store ResponseCacheStore) ServiceOption {
+	return func(srv *Service) {
+		srv.cacheStore = store
+	}
+}
+
+// CachedResponse is the response stored in ResponseCacheStore
+type CachedResponse struct {
+	Status     int
+	Header     http.Header
+	Body       []byte
+	Tags       []string
+	Created    time.Time
+	Expires    time.Time // the response is fresh until Expires
+	StaleUntil time.Time // the response can be served while revalidating until StaleUntil
+}
+
+// ResponseCacheStore stores the cached responses, implement it on a shared store(eg. redis) so that the cache can be shared across instances.
+type ResponseCacheStore interface {
+	// Get return the cached response of key, it returns nil without error if not found
+	Get(ctx context.Context, key string) (*CachedResponse, error)
+	// Set stores the response, it should be expired after ttl
+	Set(ctx context.Context, key string, resp *CachedResponse, ttl time.Duration) error
+	// Invalidate removes all the responses with any of the tags
+	Invalidate(ctx context.Context, tags ...string) error
+}
+
+// CacheTags add tags to the response to be cached, so that it can be invalidated by the tags later
+func (c *Context) CacheTags(tags ...string) {
+	c.cacheTags = append(c.cacheTags, tags...)
+}
+
+// InvalidateCache removes all the cached responses with any of the tags
+func (c *Context) InvalidateCache(tags ...string) error {
+	return c.srv.InvalidateCache(c, tags...)
+}
+
+// InvalidateCache removes all the cached responses with any of the tags
+func (srv *Service) InvalidateCache(ctx context.Context, tags ...string) error {
+	return srv.cacheStore.Invalidate(ctx, tags...)
+}
+
+// cacheFlight represents an executing request of a cache key, the other requests with the same key wait for its response
+type cacheFlight struct {
+	once sync.Once
+	done chan struct{}
+	resp *CachedResponse
+}
+
+// completeFlight publish the response(nil if not cacheable) to the waiting requests, and remove the flight
+func (srv *Service) completeFlight(key string, flight *cacheFlight, resp *CachedResponse) {
+	flight.once.Do(func() {
+		flight.resp = resp
+		srv.cacheFlightsMu.Lock()
+		if srv.cacheFlights[key] == flight {
+			delete(srv.cacheFlights, key)
+		}
+		srv.cacheFlightsMu.Unlock()
+		close(flight.done)
+	})
+}
+
+// responseCache serves a cached route, it's created for each request on the route with cache enabled
+type responseCache struct {
+	srv    *Service
+	cfg    *CacheConfig
+	key    string
+	flight *cacheFlight
+	rec    *cacheRecorder
+}
+
+// serveCache serves the request from the cache if hit, otherwise it record the response written by handler,
+// the returned responseCache must be finished after handler executed.
+func (srv *Service) serveCache(ctx *Context, route *Route) (*responseCache, bool) {
+	r := ctx.Request
+	if r.Method != http.MethodGet {
+		return nil, false
+	}
+	rc := &responseCache{
+		srv: srv,
+		cfg: route.cache,
+		key: cacheKey(r, route),
+	}
+
+	if flight, ok := r.Context().Value(cacheRevalidateKey).(*cacheFlight); ok {
+		// revalidating the stale response in background
+		rc.flight = flight
+	} else {
+		resp, err := srv.cacheStore.Get(r.Context(), rc.key)
+		if err != nil {
+			log.Ctx(r.Context()).Error().Err(err).Str("key", rc.key).Msg("Getting response cache error")
+		}
+		now := time.Now()
+		if resp != nil && now.Before(resp.Expires) {
+			writeCachedResponse(ctx, resp, "HIT")
+			return nil, true
+		}
+		if resp != nil && now.Before(resp.StaleUntil) {
+			writeCachedResponse(ctx, resp, "STALE")
+			srv.revalidateCache(r, rc.key)
+			return nil, true
+		}
+
+		// collapse the concurrent missing requests
+		srv.cacheFlightsMu.Lock()
+		if flight, ok := srv.cacheFlights[rc.key]; ok {
+			srv.cacheFlightsMu.Unlock()
+			select {
+			case <-flight.done:
+				if flight.resp != nil {
+					writeCachedResponse(ctx, flight.resp, "HIT")
+					return nil, true
+				}
+			case <-r.Context().Done():
+				return nil, true
+			}
+			// the response is not cacheable, execute the handler directly
+			return nil, false
+		}
+		rc.flight = &cacheFlight{done: make(chan struct{})}
+		srv.cacheFlights[rc.key] = rc.flight
+		srv.cacheFlightsMu.Unlock()
+	}
+
+	rc.rec = &cacheRecorder{ResponseWriter: ctx.Writer}
+	ctx.Writer = rc.rec
+	ctx.Writer.Header().Set("X-Cache", "MISS")
+	return rc, false
+}
+
+// finish stores the recorded response if it's cacheable, and wake up the collapsed requests
+func (rc *responseCache) finish(ctx *Context, failed bool) {
+	var resp *CachedResponse
+	if !failed && rc.rec.status == http.StatusOK && ctx.Writer.Header().Get("Set-Cookie") == "" {
+		now := time.Now()
+		header := cloneRecordedHeader(ctx.Writer.Header())
+		header.Del("X-Cache")
+		resp = &CachedResponse{
+			Status:     rc.rec.status,
+			Header:     header,
+			Body:       rc.rec.body,
+			Tags:       append(append([]string{}, rc.cfg.Tags...), ctx.cacheTags...),
+			Created:    now,
+			Expires:    now.Add(rc.cfg.TTL),
+			StaleUntil: now.Add(rc.cfg.TTL + rc.cfg.StaleWhileRevalidate),
+		}
+		if err := rc.srv.cacheStore.Set(ctx.Request.Context(), rc.key, resp, rc.cfg.TTL+rc.cfg.StaleWhileRevalidate); err != nil {
+			log.Ctx(ctx).Error().Err(err).Str("key", rc.key).Msg("Setting response cache error")
+		}
+	}
+	if rc.flight != nil {
+		rc.srv.completeFlight(rc.key, rc.flight, resp)
+	}
+}
+
+// revalidateCache replay the request in background to refresh the stale response, it's skipped if the key is being refreshed
+func (srv *Service) revalidateCache(r *http.Request, key string) {
+	srv.cacheFlightsMu.Lock()
+	if _, ok := srv.cacheFlights[key]; ok {
+		srv.cacheFlightsMu.Unlock()
+		return
+	}
+	flight := &cacheFlight{done: make(chan struct{})}
+	srv.cacheFlights[key] = flight
+	srv.cacheFlightsMu.Unlock()
+
+	ctx := context.WithValue(context.WithoutCancel(r.Context()), cacheRevalidateKey, flight)
+	req := r.Clone(ctx)
+	req.Body = http.NoBody
+	go func() {
+		// complete the flight in case of the request didn't reach the handler
+		defer srv.completeFlight(key, flight, nil)
+		srv.ServeHTTP(&discardResponseWriter{header: make(http.Header)}, req)
+	}()
+}
+
+func writeCachedResponse(ctx *Context, resp *CachedResponse, state string) {
+	header := ctx.Writer.Header()
+	for k, v := range resp.Header {
+		header[k] = append([]string{}, v...)
+	}
+	header.Set("X-Cache", state)
+	header.Set("Age", strconv.Itoa(int(time.Since(resp.Created).Seconds())))
+	ctx.returned = true
+	if etag := resp.Header.Get("ETag"); etag != "" {
+		if inm := ctx.Request.Header.Get("If-None-Match"); inm != "" && matchETag(inm, etag, true) {
+			ctx.Writer.WriteHeader(http.StatusNotModified)
+			return
+		}
+	}
+	ctx.Writer.WriteHeader(resp.Status)
+	ctx.Writer.Write(resp.Body)
+}
+
+// cacheKey generates the cache key by method, route pattern, query parameters, headers and principal
+func cacheKey(r *http.Request, route *Route) string {
+	var (
+		cfg   = route.cache
+		b     strings.Builder
+		query = r.URL.Query()
+	)
+	b.WriteString(r.Method)
+	b.WriteByte(' ')
+	b.WriteString(route.Pattern)
+	b.WriteByte(' ')
+	b.WriteString(r.URL.Path)
+	b.WriteByte('?')
+	if len(cfg.Query) > 0 {
+		selected := url.Values{}
+		for _, name := range cfg.Query {
+			if values, ok := query[name]; ok {
+				selected[name] = values
+			}
+		}
+		query = selected
+	}
+	b.WriteString(query.Encode()) // Encode sorts the keys
+	headers := append([]string{}, cfg.Headers...)
+	sort.Strings(headers)
+	for _, name := range headers {
+		b.WriteByte('\n')
+		b.WriteString(name)
+		b.WriteByte(':')
+		b.WriteString(strings.Join(r.Header.Values(name), ","))
+	}
+	if cfg.PerPrincipal {
+		b.WriteString("\nprincipal:")
+		if p := PrincipalFrom(r.Context()); p != nil {
+			b.WriteString(p.ID)
+		}
+	}
+	sum := sha256.Sum256([]byte(b.String()))
+	return hex.EncodeToString(sum[:])
+}
+
+// cacheRecorder records the status and body written into response
+type cacheRecorder struct {
+	http.ResponseWriter
+	status int
+	body   []byte
+	failed bool // the response is an error responded by handler, it's marked by markFailed
+}
+
+// markFailed marks the recorders in the writer chain that the response is an error, whatever the envelope renders
+func markFailed(w http.ResponseWriter) {
+	for w != nil {
+		if rec, ok := w.(*cacheRecorder); ok {
+			rec.failed = true
+		}
+		u, ok := w.(interface{ Unwrap() http.ResponseWriter })
+		if !ok {
+			return
+		}
+		w = u.Unwrap()
+	}
+}
+
+func (rec *cacheRecorder) WriteHeader(code int) {
+	if rec.status == 0 {
+		rec.status = code
+	}
+	rec.ResponseWriter.WriteHeader(code)
+}
+
+func (rec *cacheRecorder) Write(p []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	rec.body = append(rec.body, p...)
+	return rec.ResponseWriter.Write(p)
+}
+
+// Unwrap return the original http.ResponseWriter, it's used by http.ResponseController
+func (rec *cacheRecorder) Unwrap() http.ResponseWriter {
+	return rec.ResponseWriter
+}
+
+// cloneRecordedHeader clone the header of recorded response.
+// The body is recorded before compression, so the encoding headers and the encoding suffix of ETag are removed, they will be
+// set again on replaying.
+func cloneRecordedHeader(h http.Header) http.Header {
+	header := h.Clone()
+	for _, name := range []string{"Content-Encoding", "Content-Length", "Vary"} {
+		header.Del(name)
+	}
+	if etag := header.Get("ETag"); etag != "" {
+		header.Set("ETag", trimETagEncoding(etag))
+	}
+	return header
+}
+
+type discardResponseWriter struct {
+	header http.Header
+}
+
+func (w *discardResponseWriter) Header() http.Header         { return w.header }
+func (w *discardResponseWriter) Write(p []byte) (int, error) { return len(p), nil }
+func (w *discardResponseWriter) WriteHeader(int)             {}
+
+type memoryCacheEntry struct {
+	key    string
+	resp   *CachedResponse
+	expire time.Time
+	size   int64
+}
+
+// memoryCacheStore is an in-memory LRU ResponseCacheStore limited by entries count and body size
+type memoryCacheStore struct {
+	mu         sync.Mutex
+	maxEntries int
+	maxBytes   int64
+	size       int64
+	ll         *list.List
+	items      map[string]*list.Element
+	tags       map[string]map[string]struct{}
+}
+
+// NewMemoryCacheStore create an in-memory LRU ResponseCacheStore, the least recently used responses are evicted
+// when the count exceeds maxEntries or the total body size exceeds maxBytes.
+func NewMemoryCacheStore(maxEntries int, maxBytes int64) ResponseCacheStore {
+	if maxEntries <= 0 {
+		maxEntries = defaultCacheMaxEntries
+	}
+	if maxBytes <= 0 {
+		maxBytes = defaultCacheMaxBytes
+	}
+	return &memoryCacheStore{
+		maxEntries: maxEntries,
+		maxBytes:   maxBytes,
+		ll:         list.New(),
+		items:      make(map[string]*list.Element),
+		tags:       make(map[string]map[string]struct{}),
+	}
+}
+
+func (s *memoryCacheStore) Get(_ context.Context, key string) (*CachedResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	elem, ok := s.items[key]
+	if !ok {
+		return nil, nil
+	}
+	entry := elem.Value.(*memoryCacheEntry)
+	if time.Now().After(entry.expire) {
+		s.remove(elem)
+		return nil, nil
+	}
+	s.ll.MoveToFront(elem)
+	return entry.resp, nil
+}
+
+func (s *memoryCacheStore) Set(_ context.Context, key string, resp *CachedResponse, ttl time.Duration) error {
+	entry := &memoryCacheEntry{
+		key:    key,
+		resp:   resp,
+		expire: time.Now().Add(ttl),
+		size:   int64(len(resp.Body)),
+	}
+	if entry.size > s.maxBytes {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if elem, ok := s.items[key]; ok {
+		s.remove(elem)
+	}
+	s.items[key] = s.ll.PushFront(entry)
+	s.size += entry.size
+	for _, tag := range resp.Tags {
+		if s.tags[tag] == nil {
+			s.tags[tag] = make(map[string]struct{})
+		}
+		s.tags[tag][key] = struct{}{}
+	}
+	for s.ll.Len() > s.maxEntries || s.size > s.maxBytes {
+		s.remove(s.ll.Back())
+	}
+	return nil
+}
+
+func (s *memoryCacheStore) Invalidate(_ context.Context, tags ...string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, tag := range tags {
+		for key := range s.tags[tag] {
+			if elem, ok := s.items[key]; ok {
+				s.remove(elem)
+			}
+		}
+		delete(s.tags, tag)
+	}
+	return nil
+}
+
+func (s *memoryCacheStore) remove(elem *list.Element) {
+	entry := elem.Value.(*memoryCacheEntry)
+	s.ll.Remove(elem)
+	delete(s.items, entry.key)
+	s.size -= entry.size
+	for _, tag := range entry.resp.Tags {
+		if keys, ok := s.tags[tag]; ok {
+			delete(keys, entry.key)
+			if len(keys) == 0 {
+				delete(s.tags, tag)
+			}
+		}
+	}
+}
@@ -0,0 +1,371 @@
+package apix
+
+import (
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/flate"
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	defaultCompressMinSize      = 1024
+	defaultMaxDecompressedSize  = 10 << 20
+	defaultZstdDecoderMaxMemory = 64 << 20
+	compressionEncodingZstd     = "zstd"
+	compressionEncodingBrotli   = "br"
+	compressionEncodingGzip     = "gzip"
+	compressionEncodingDeflate  = "deflate"
+	compressionEncodingIdentity = "identity"
+)
+
+var (
+	defaultCompressEncodings = []string{
+		compressionEncodingZstd,
+		compressionEncodingBrotli,
+		compressionEncodingGzip,
+		compressionEncodingDeflate,
+	}
+	defaultCompressContentTypes = []string{
+		"text/*",
+		"application/json",
+		"application/*+json",
+		"application/javascript",
+		"application/xml",
+		"application/*+xml",
+		"image/svg+xml",
+	}
+)
+
+// CompressionConfig configures the response compression and request body decompression
+type CompressionConfig struct {
+	// Encodings is the supported encodings in server preference order, default: zstd, br, gzip, deflate
+	Encodings []string
+	// MinSize is the minimum size of response to be compressed, default 1024
+	MinSize int
+	// ContentTypes is the allow-list of response content types to be compressed, wildcard pattern supported(e.g. "text/*").
+	// By default, text, json, javascript and xml are compressed.
+	ContentTypes []string
+	// MaxDecompressedSize limits the size of request body after decompression to protect from decompression bombs, default 10MB.
+	MaxDecompressedSize int64
+	// DisableDecompression disables the decompression of request body with Content-Encoding
+	DisableDecompression bool
+}
+
+// WithCompression enables the compression for all the service responses(including grpc-gateway), negotiated by Accept-Encoding header.
+// It also decompresses the request body according to Content-Encoding header before binding and Context.Body().
+func WithCompression(cfg CompressionConfig) ServiceOption {
+	return func(srv *Service) {
+		srv.compression = &cfg
+	}
+}
+
+// Compress create a middleware compressing the response, use it on group or single route instead of WithCompression on whole service
+func Compress(cfg CompressionConfig) Middleware {
+	if len(cfg.Encodings) == 0 {
+		cfg.Encodings = defaultCompressEncodings
+	}
+	if cfg.MinSize <= 0 {
+		cfg.MinSize = defaultCompressMinSize
+	}
+	if len(cfg.ContentTypes) == 0 {
+		cfg.ContentTypes = defaultCompressContentTypes
+	}
+	if cfg.MaxDecompressedSize <= 0 {
+		cfg.MaxDecompressedSize = defaultMaxDecompressedSize
+	}
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			if !cfg.DisableDecompression {
+				if err := decompressRequest(w, r, cfg.MaxDecompressedSize); err != nil {
+					ReturnJSON(w, http.StatusUnsupportedMediaType, ResponseBody{Code: http.StatusUnsupportedMediaType, Message: err.Error()})
+					return
+				}
+			}
+
+			encoding := negotiateEncoding(r.Header.Get("Accept-Encoding"), cfg.Encodings)
+			if encoding == "" || r.Method == http.MethodHead || r.Header.Get("Range") != "" {
+				next(w, r)
+				return
+			}
+			cw := &compressWriter{
+				ResponseWriter: w,
+				cfg:            &cfg,
+				encoding:       encoding,
+				status:         http.StatusOK,
+			}
+			defer cw.Close()
+			next(cw, r)
+		}
+	}
+}
+
+// decompressRequest replace the request body with the decompressed reader according to Content-Encoding header
+func decompressRequest(w http.ResponseWriter, r *http.Request, maxSize int64) error {
+	encoding := strings.ToLower(strings.TrimSpace(r.Header.Get("Content-Encoding")))
+	if encoding == "" || encoding == compressionEncodingIdentity || r.Body == nil || r.Body == http.NoBody {
+		return nil
+	}
+	var (
+		body io.ReadCloser
+		err  error
+	)
+	switch encoding {
+	case compressionEncodingGzip, "x-gzip":
+		body, err = gzip.NewReader(r.Body)
+	case compressionEncodingDeflate:
+		body = flate.NewReader(r.Body)
+	case compressionEncodingBrotli:
+		body = io.NopCloser(brotli.NewReader(r.Body))
+	case compressionEncodingZstd:
+		var d *zstd.Decoder
+		d, err = zstd.NewReader(r.Body, zstd.WithDecoderConcurrency(1), zstd.WithDecoderMaxMemory(defaultZstdDecoderMaxMemory))
+		if err == nil {
+			body = d.IOReadCloser()
+		}
+	default:
+		return &unsupportedEncodingError{encoding: encoding}
+	}
+	if err != nil {
+		return err
+	}
+	r.Body = &decompressedBody{
+		Reader: http.MaxBytesReader(w, body, maxSize),
+		dec:    body,
+		raw:    r.Body,
+	}
+	r.Header.Del("Content-Encoding")
+	r.Header.Del("Content-Length")
+	r.ContentLength = -1
+	return nil
+}
+
+type unsupportedEncodingError struct {
+	encoding string
+}
+
+func (e *unsupportedEncodingError) Error() string {
+	return "unsupported content encoding: " + e.encoding
+}
+
+type decompressedBody struct {
+	io.Reader
+	dec io.Closer
+	raw io.Closer
+}
+
+func (b *decompressedBody) Close() error {
+	b.dec.Close()
+	return b.raw.Close()
+}
+
+// negotiateEncoding choose the encoding by the Accept-Encoding header, it return empty string if no acceptable encoding
+func negotiateEncoding(accept string, encodings []string) string {
+	if accept == "" {
+		return ""
+	}
+	var (
+		qvalues  = make(map[string]float64)
+		wildcard = -1.0
+	)
+	for _, item := range strings.Split(accept, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(item), ";")
+		name = strings.ToLower(strings.TrimSpace(name))
+		q := 1.0
+		if params != "" {
+			if _, v, ok := strings.Cut(strings.TrimSpace(params), "q="); ok {
+				if f, err := strconv.ParseFloat(strings.TrimSpace(v), 64); err == nil {
+					q = f
+				}
+			}
+		}
+		if name == "*" {
+			wildcard = q
+		} else {
+			qvalues[name] = q
+		}
+	}
+	var (
+		chosen string
+		best   float64
+	)
+	for _, encoding := range encodings {
+		q, ok := qvalues[encoding]
+		if !ok {
+			q = wildcard
+		}
+		if q > best {
+			chosen, best = encoding, q
+		}
+	}
+	return chosen
+}
+
+// compressor is the common interface of the compression writers
+type compressor interface {
+	io.WriteCloser
+	Flush() error
+	Reset(io.Writer)
+}
+
+var compressorPools = map[string]*sync.Pool{
+	compressionEncodingGzip: {New: func() any {
+		return gzip.NewWriter(nil)
+	}},
+	compressionEncodingDeflate: {New: func() any {
+		w, _ := flate.NewWriter(nil, flate.DefaultCompression)
+		return w
+	}},
+	compressionEncodingBrotli: {New: func() any {
+		return brotli.NewWriter(nil)
+	}},
+	compressionEncodingZstd: {New: func() any {
+		w, _ := zstd.NewWriter(nil, zstd.WithEncoderConcurrency(1))
+		return w
+	}},
+}
+
+// compressWriter buffers the response until MinSize reached, then decide whether to compress it by the content type
+type compressWriter struct {
+	http.ResponseWriter
+	cfg      *CompressionConfig
+	encoding string
+	enc      compressor
+	buf      []byte
+	status   int
+	decided  bool
+	closed   bool
+}
+
+func (cw *compressWriter) WriteHeader(code int) {
+	if cw.decided {
+		return
+	}
+	cw.status = code
+	if code < 200 || code == http.StatusNoContent || code == http.StatusNotModified {
+		cw.decide(false)
+	}
+}
+
+func (cw *compressWriter) Write(p []byte) (int, error) {
+	if !cw.decided {
+		if !cw.compressible() {
+			cw.decide(false)
+		} else {
+			cw.buf = append(cw.buf, p...)
+			if len(cw.buf) >= cw.cfg.MinSize {
+				cw.decide(true)
+			}
+			return len(p), nil
+		}
+	}
+	if cw.enc != nil {
+		return cw.enc.Write(p)
+	}
+	return cw.ResponseWriter.Write(p)
+}
+
+// Flush compresses the response if it's compressible regardless of MinSize, because the response is streaming
+func (cw *compressWriter) Flush() {
+	if !cw.decided {
+		cw.decide(cw.compressible())
+	}
+	if cw.enc != nil {
+		cw.enc.Flush()
+	}
+	if flusher, ok := cw.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Unwrap return the original http.ResponseWriter, it's used by http.ResponseController
+func (cw *compressWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
+
+// Close flush the buffered data and put the encoder back into pool
+func (cw *compressWriter) Close() error {
+	if cw.closed {
+		return nil
+	}
+	cw.closed = true
+	if !cw.decided {
+		cw.decide(false)
+	}
+	if cw.enc == nil {
+		return nil
+	}
+	err := cw.enc.Close()
+	cw.enc.Reset(nil)
+	compressorPools[cw.encoding].Put(cw.enc)
+	cw.enc = nil
+	return err
+}
+
+// compressible report whether the response may be compressed by its headers
+func (cw *compressWriter) compressible() bool {
+	header := cw.Header()
+	if header.Get("Content-Encoding") != "" || header.Get("Content-Range") != "" {
+		return false
+	}
+	contentType := header.Get("Content-Type")
+	if contentType == "" {
+		return true // detect it by the buffered content later
+	}
+	return cw.allowedContentType(contentType)
+}
+
+func (cw *compressWriter) allowedContentType(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	for _, pattern := range cw.cfg.ContentTypes {
+		if matchStr(pattern, mediaType) {
+			return true
+		}
+	}
+	return false
+}
+
+// decide write the header and buffered data into response, with the compression enabled or not
+func (cw *compressWriter) decide(compress bool) {
+	cw.decided = true
+	header := cw.Header()
+	if header.Get("Content-Type") == "" && len(cw.buf) > 0 {
+		// set the content type before compressing, otherwise net/http will detect it by the compressed data
+		header.Set("Content-Type", http.DetectContentType(cw.buf))
+	}
+	if header.Get("Content-Encoding") == "" && cw.allowedContentType(header.Get("Content-Type")) {
+		// the response varies by Accept-Encoding even if it's too small to compress this time
+		header.Add("Vary", "Accept-Encoding")
+	} else {
+		compress = false
+	}
+	if compress {
+		header.Set("Content-Encoding", cw.encoding)
+		header.Del("Content-Length")
+		if etag := header.Get("ETag"); strings.HasPrefix(etag, `"`) {
+			// the compressed content is another representation, it keeps the strong etag suffixed by the encoding(e.g:
+			// "abc-gzip"), so that the conditional updates by If-Match work, the suffix is ignored by matchETag
+			header.Set("ETag", strings.TrimSuffix(trimETagEncoding(etag), `"`)+"-"+cw.encoding+`"`)
+		}
+		cw.enc = compressorPools[cw.encoding].Get().(compressor)
+		cw.enc.Reset(cw.ResponseWriter)
+	}
+	cw.ResponseWriter.WriteHeader(cw.status)
+	if len(cw.buf) > 0 {
+		if cw.enc != nil {
+			cw.enc.Write(cw.buf)
+		} else {
+			cw.ResponseWriter.Write(cw.buf)
+		}
+		cw.buf = nil
+	}
+}
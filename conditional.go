@@ -0,0 +1,140 @@
+package apix
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// WithETag computes the ETag from the marshaled response of GET and HEAD requests, and response 304 if it matches the If-None-Match header.
+// The ETag is weak if weak is true, note that the weak ETags never match If-Match, do not use them on the resources updated
+// conditionally. The compressed responses keep the strong ETags by suffixing the encoding, e.g: "abc-gzip".
+func WithETag(weak bool) RouteOption {
+	return func(r *Route) {
+		r.etag = true
+		r.weakETag = weak
+	}
+}
+
+// CacheControl set the Cache-Control header on the successful responses of GET and HEAD requests, e.g: "public, max-age=60"
+func CacheControl(policy string) RouteOption {
+	return func(r *Route) {
+		r.cacheControl = policy
+	}
+}
+
+// SetETag set the ETag of response, the If-None-Match header will be checked against it on returning.
+// The tag will be quoted if not.
+func (c *Context) SetETag(tag string, weak bool) {
+	c.Writer.Header().Set("ETag", formatETag(tag, weak))
+}
+
+// SetLastModified set the Last-Modified header of response, the If-Modified-Since header will be checked against it on returning.
+func (c *Context) SetLastModified(t time.Time) {
+	if t.IsZero() {
+		return
+	}
+	c.Writer.Header().Set("Last-Modified", t.UTC().Format(http.TimeFormat))
+}
+
+// CheckPreconditions evaluates the If-Match and If-Unmodified-Since headers against the current etag and last modified time of the resource.
+// The etag is the opaque strong tag of the current resource, If-Match is evaluated by the strong comparison(RFC 9110), so
+// the weak tags never match. It's used in unsafe methods like PUT and PATCH, for avoiding the lost update problem.
+// If the preconditions failed, it responses 412 status and return false, the handler should return nil data and error directly then.
+func (c *Context) CheckPreconditions(etag string, lastModified time.Time) bool {
+	r := c.Request
+	if im := r.Header.Get("If-Match"); im != "" {
+		if etag == "" || !matchETag(im, formatETag(etag, false), false) {
+			c.Fail(http.StatusPreconditionFailed, ResponseBody{Code: http.StatusPreconditionFailed, Message: "precondition failed"})
+			return false
+		}
+	} else if ius := r.Header.Get("If-Unmodified-Since"); ius != "" && !lastModified.IsZero() {
+		t, err := http.ParseTime(ius)
+		if err == nil && lastModified.Truncate(time.Second).After(t) {
+			c.Fail(http.StatusPreconditionFailed, ResponseBody{Code: http.StatusPreconditionFailed, Message: "precondition failed"})
+			return false
+		}
+	}
+	return true
+}
+
+// notModified set the ETag and Cache-Control headers for the response content, and report whether the request's
+// cached content is still fresh, so that 304 should be responsed.
+func (c *Context) notModified(status int, content []byte) bool {
+	r := c.Request
+	if c.failed || status != http.StatusOK || (r.Method != http.MethodGet && r.Method != http.MethodHead) {
+		// the errors are responded with 200 status by default, they must not be cached
+		return false
+	}
+	header := c.Writer.Header()
+	if c.route != nil {
+		if c.route.cacheControl != "" && header.Get("Cache-Control") == "" {
+			header.Set("Cache-Control", c.route.cacheControl)
+		}
+		if c.route.etag && header.Get("ETag") == "" {
+			sum := sha256.Sum256(content)
+			header.Set("ETag", formatETag(hex.EncodeToString(sum[:16]), c.route.weakETag))
+		}
+	}
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		etag := header.Get("ETag")
+		return etag != "" && matchETag(inm, etag, true)
+	}
+	if ims := r.Header.Get("If-Modified-Since"); ims != "" {
+		lm, err := http.ParseTime(header.Get("Last-Modified"))
+		if err != nil {
+			return false
+		}
+		t, err := http.ParseTime(ims)
+		return err == nil && !lm.After(t)
+	}
+	return false
+}
+
+func formatETag(tag string, weak bool) string {
+	if !strings.HasPrefix(tag, `"`) && !strings.HasPrefix(tag, `W/"`) {
+		tag = `"` + tag + `"`
+	}
+	if weak && !strings.HasPrefix(tag, "W/") {
+		tag = "W/" + tag
+	}
+	return tag
+}
+
+// matchETag report whether the etag matches one of the tags in If-Match or If-None-Match header.
+// The weak comparison is used for If-None-Match, and strong comparison for If-Match. The encoding suffix added by compression
+// is ignored, since the compressed response is the same resource.
+func matchETag(header, etag string, weak bool) bool {
+	if strings.TrimSpace(header) == "*" {
+		return true
+	}
+	if !weak && strings.HasPrefix(etag, "W/") {
+		return false
+	}
+	etag = trimETagEncoding(strings.TrimPrefix(etag, "W/"))
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		if strings.HasPrefix(tag, "W/") {
+			if !weak {
+				continue
+			}
+			tag = tag[2:]
+		}
+		if trimETagEncoding(tag) == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// trimETagEncoding removes the encoding suffix added by compression from the quoted etag, e.g: "abc-gzip" to "abc"
+func trimETagEncoding(etag string) string {
+	for encoding := range compressorPools {
+		if suffix := "-" + encoding + `"`; strings.HasSuffix(etag, suffix) {
+			return etag[:len(etag)-len(suffix)] + `"`
+		}
+	}
+	return etag
+}
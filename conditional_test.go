@@ -0,0 +1,94 @@
+package apix
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMatchETag(t *testing.T) {
+	tests := []struct {
+		header string
+		etag   string
+		weak   bool
+		want   bool
+	}{
+		{`*`, `"a"`, false, true},
+		{`"a"`, `"a"`, false, true},
+		{`"b", "a"`, `"a"`, false, true},
+		{`"b"`, `"a"`, false, false},
+		{`W/"a"`, `"a"`, false, false},
+		{`"a"`, `W/"a"`, false, false},
+		{`W/"a"`, `"a"`, true, true},
+		{`"a"`, `W/"a"`, true, true},
+		{`"a-gzip"`, `"a"`, false, true},
+		{`"a"`, `"a-br"`, false, true},
+		{`"a-gzip"`, `"a-zstd"`, true, true},
+		{`"a-other"`, `"a"`, false, false},
+	}
+	for _, tt := range tests {
+		if got := matchETag(tt.header, tt.etag, tt.weak); got != tt.want {
+			t.Errorf("matchETag(%q, %q, %v) = %v, want %v", tt.header, tt.etag, tt.weak, got, tt.want)
+		}
+	}
+}
+
+type getETagDocument struct{}
+
+func (h *getETagDocument) Execute(ctx *Context) (any, error) {
+	ctx.SetETag("v1", false)
+	return strings.Repeat("content ", 256), nil
+}
+
+type putETagDocument struct{}
+
+func (h *putETagDocument) Execute(ctx *Context) (any, error) {
+	if !ctx.CheckPreconditions("v1", time.Time{}) {
+		return nil, nil
+	}
+	return "updated", nil
+}
+
+func TestConditionalRequests(t *testing.T) {
+	srv := New(WithCompression(CompressionConfig{}))
+	srv.GET("/doc", &getETagDocument{})
+	srv.PUT("/doc", &putETagDocument{})
+
+	r := httptest.NewRequest("GET", "/doc", nil)
+	r.Header.Set("Accept-Encoding", "gzip")
+	w := httptest.NewRecorder()
+	srv.ServeHTTP(w, r)
+	etag := w.Header().Get("ETag")
+	if w.Header().Get("Content-Encoding") != "gzip" || etag != `"v1-gzip"` {
+		t.Fatalf("got Content-Encoding %q and ETag %q", w.Header().Get("Content-Encoding"), etag)
+	}
+
+	r = httptest.NewRequest("GET", "/doc", nil)
+	r.Header.Set("If-None-Match", etag)
+	w = httptest.NewRecorder()
+	srv.ServeHTTP(w, r)
+	if w.Code != http.StatusNotModified {
+		t.Errorf("got status %d for If-None-Match %s, want 304", w.Code, etag)
+	}
+
+	tests := []struct {
+		ifMatch string
+		want    int
+	}{
+		{`"v1"`, http.StatusOK},
+		{etag, http.StatusOK},
+		{`W/"v1"`, http.StatusPreconditionFailed},
+		{`"v0"`, http.StatusPreconditionFailed},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("PUT", "/doc", nil)
+		r.Header.Set("If-Match", tt.ifMatch)
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, r)
+		if w.Code != tt.want {
+			t.Errorf("got status %d for If-Match %s, want %d", w.Code, tt.ifMatch, tt.want)
+		}
+	}
+}
@@ -24,16 +24,26 @@ const (
 	contextKey = 1
 )
 
+const (
+	principalKey ctxKeyType = iota + 1
+	cacheRevalidateKey
+	routeKey
+	gatewayRequestKey
+)
+
 // Context is the most important part of gin. It allows us to pass variables between middleware,
 // manage the flow, validate the JSON of a request and render a JSON response for example.
 type Context struct {
-	Request  *http.Request
-	Writer   http.ResponseWriter
-	mu       sync.RWMutex
-	Keys     map[string]any
-	srv      *Service
-	body     *bytespool.ByteBuffer
-	returned bool
+	Request   *http.Request
+	Writer    http.ResponseWriter
+	mu        sync.RWMutex
+	Keys      map[string]any
+	srv       *Service
+	route     *Route
+	body      *bytespool.ByteBuffer
+	returned  bool
+	failed    bool // the response is an error responded by returnError
+	cacheTags []string
 }
 
 // Ctx peek *apix.Context from the given context, it return nil if *apix.Context not exist
@@ -50,9 +60,12 @@ func (c *Context) reset() {
 	c.Writer = nil
 	c.Keys = nil
 	c.srv = nil
+	c.route = nil
 	bbp.Put(c.body)
 	c.body = nil
 	c.returned = false
+	c.failed = false
+	c.cacheTags = nil
 }
 
 // With add self into given ctx by context.WithValue, and return the new context
@@ -60,6 +73,11 @@ func (c *Context) With(ctx context.Context) context.Context {
 	return context.WithValue(ctx, contextKey, c)
 }
 
+// Route return the route matched by the request
+func (c *Context) Route() *Route {
+	return c.route
+}
+
 // Body return the body bytes
 func (c *Context) Body() []byte {
 	if c.body != nil {
@@ -127,8 +145,17 @@ func (c *Context) Return(status int, data any, marshaler func(any) ([]byte, erro
 		// warn log
 		return
 	}
-	Return(c.Writer, status, data, marshaler)
 	c.returned = true
+	content := marshalContent(data, marshaler)
+	if status <= 0 {
+		status = 200
+	}
+	if c.notModified(status, content) {
+		c.Writer.WriteHeader(http.StatusNotModified)
+		return
+	}
+	c.Writer.WriteHeader(status)
+	c.Writer.Write(content)
 }
 
 func (c *Context) SetContentType(s string) {
@@ -143,22 +170,20 @@ func (c *Context) ReturnText(status int, data any) {
 	c.Return(status, data, MarshalText)
 }
 
+// Fail responds the error wrapped by the envelope of route
 func (c *Context) Fail(status int, err error) {
-	if _, ok := err.(ResponseBody); !ok {
-		err = ResponseBody{
+	body, ok := err.(ResponseBody)
+	if !ok {
+		body = ResponseBody{
 			Code:    1,
 			Message: err.Error(),
 		}
 	}
-	c.Return(status, err, json.Marshal)
+	c.returnError(status, body, err)
 }
 
 func (c *Context) Failf(status int, msg string, args ...any) {
-	err := ResponseBody{
-		Code:    1,
-		Message: fmt.Errorf(msg, args...).Error(),
-	}
-	c.Return(status, err, json.Marshal)
+	c.Fail(status, fmt.Errorf(msg, args...))
 }
 
 func MarshalText(data any) ([]byte, error) {
@@ -0,0 +1,122 @@
+package apix
+
+import (
+	"expvar"
+	"net/http"
+	"net/http/pprof"
+	"runtime/debug"
+	"strings"
+)
+
+const defaultDebugPrefix = "/debug"
+
+// DebugOptions configures the debug endpoints
+type DebugOptions struct {
+	// Prefix is the url prefix of debug endpoints, default "/debug"
+	Prefix string
+	// Guard protects the debug endpoints, e.g: an authentication middleware allowing the operators only
+	Guard Middleware
+	// Addr is the address of a separate admin listener serving the debug endpoints, it's started by Service.ListenAndServe.
+	// Serve the handler returned by Service.AdminHandler if the service is hosted by Server.
+	// The debug endpoints are not mounted on the service if it's set.
+	Addr string
+}
+
+// WithDebug mounts the debug endpoints under the prefix:
+//
+//   - /pprof/ the net/http/pprof profiles
+//   - /vars the expvar variables
+//   - /goroutines the stack dump of all goroutines
+//   - /routes the route table of service
+//   - /buildinfo the build info of binary
+//
+// Either Guard or Addr is required, so that the debug endpoints never become public on the main listener by accident.
+func WithDebug(opts DebugOptions) ServiceOption {
+	if opts.Guard == nil && opts.Addr == "" {
+		panic("debug endpoints require a guard middleware or an admin listener address")
+	}
+	if opts.Prefix == "" {
+		opts.Prefix = defaultDebugPrefix
+	}
+	opts.Prefix = "/" + strings.Trim(opts.Prefix, "/")
+	return func(srv *Service) {
+		srv.debug = &opts
+	}
+}
+
+// registerDebugEndpoints registers the debug endpoints on the service, or on the admin listener if Addr specified
+func (srv *Service) registerDebugEndpoints() {
+	opts := srv.debug
+	handlers := []struct {
+		path    string
+		handler http.Handler
+	}{
+		{"/pprof/{$}", http.HandlerFunc(pprof.Index)},
+		{"/pprof/cmdline", http.HandlerFunc(pprof.Cmdline)},
+		{"/pprof/profile", http.HandlerFunc(pprof.Profile)},
+		{"/pprof/symbol", http.HandlerFunc(pprof.Symbol)},
+		{"/pprof/trace", http.HandlerFunc(pprof.Trace)},
+		{"/pprof/{profile}", http.HandlerFunc(serveProfile)},
+		{"/vars", expvar.Handler()},
+		{"/goroutines", http.HandlerFunc(serveGoroutines)},
+		{"/routes", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { ReturnJSON(w, http.StatusOK, srv.Routes()) })},
+		{"/buildinfo", http.HandlerFunc(serveBuildInfo)},
+	}
+
+	if opts.Addr == "" {
+		middlewares := append(append([]Middleware{}, srv.middlewares...), opts.Guard)
+		for _, h := range handlers {
+			srv.handle("", opts.Prefix+h.path, h.handler, middlewares, nil)
+		}
+		return
+	}
+	mux := http.NewServeMux()
+	for _, h := range handlers {
+		mux.Handle(opts.Prefix+h.path, h.handler)
+	}
+	var handler http.Handler = mux
+	if opts.Guard != nil {
+		handler = opts.Guard(mux.ServeHTTP)
+	}
+	srv.adminHandler = handler
+}
+
+// AdminHandler returns the handler of admin listener, it's nil if the debug endpoints are not served on a separate listener
+func (srv *Service) AdminHandler() http.Handler {
+	return srv.adminHandler
+}
+
+// serveProfile serves the named profile, e.g: heap, allocs, goroutine, it works under any prefix unlike pprof.Index
+func serveProfile(w http.ResponseWriter, r *http.Request) {
+	pprof.Handler(r.PathValue("profile")).ServeHTTP(w, r)
+}
+
+func serveGoroutines(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	q.Set("debug", "2")
+	r.URL.RawQuery = q.Encode()
+	pprof.Handler("goroutine").ServeHTTP(w, r)
+}
+
+func serveBuildInfo(w http.ResponseWriter, r *http.Request) {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		ReturnJSON(w, http.StatusNotFound, ResponseBody{Code: http.StatusNotFound, Message: "build info not available"})
+		return
+	}
+	settings := make(map[string]string, len(info.Settings))
+	for _, s := range info.Settings {
+		settings[s.Key] = s.Value
+	}
+	deps := make(map[string]string, len(info.Deps))
+	for _, d := range info.Deps {
+		deps[d.Path] = d.Version
+	}
+	ReturnJSON(w, http.StatusOK, map[string]any{
+		"go_version": info.GoVersion,
+		"path":       info.Path,
+		"main":       info.Main.Path + "@" + info.Main.Version,
+		"settings":   settings,
+		"deps":       deps,
+	})
+}
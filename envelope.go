@@ -0,0 +1,155 @@
+package apix
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+var (
+	// DefaultEnvelope wraps the data as {"code":0,"data":...} and the errors as {"code":1,"message":"..."}
+	DefaultEnvelope Envelope = defaultEnvelope{}
+	// RawEnvelope responds the data as it is, and the errors as {"code":...,"message":"..."} with the 4xx or 5xx status
+	RawEnvelope Envelope = rawEnvelope{}
+)
+
+// Envelope shapes the response bodies of native routes and grpc-gateway routes, implement it to add the extra fields,
+// e.g: request_id or server_time. The responses written by middlewares directly are not wrapped.
+type Envelope interface {
+	// Success returns the response body of the data returned by handler, the data of gateway is the json.RawMessage
+	// marshaled from the gRPC response
+	Success(r *http.Request, data any) any
+	// Error returns the http status and response body of error, status is the status responded by default(200 for the
+	// errors returned by handlers and gRPC services), body carries the error code and message, and cause is the original
+	// error, e.g: the gRPC status error of gateway
+	Error(r *http.Request, status int, body ResponseBody, cause error) (int, any)
+}
+
+// WithDefaultEnvelope specifics the response envelope of service, DefaultEnvelope is used if not specified
+func WithDefaultEnvelope(env Envelope) ServiceOption {
+	return func(srv *Service) {
+		srv.envelope = env
+	}
+}
+
+// WithEnvelope overrides the response envelope of route, use it on a Group by Group.With to override the routes of group.
+// It applies to the native routes only, the grpc-gateway routes are not registered with RouteOptions, they always use
+// the envelope of service specified by WithDefaultEnvelope.
+func WithEnvelope(env Envelope) RouteOption {
+	return func(r *Route) {
+		r.envelope = env
+	}
+}
+
+// envelope return the envelope of route, or the default envelope of service
+func (c *Context) envelope() Envelope {
+	if c.route != nil && c.route.envelope != nil {
+		return c.route.envelope
+	}
+	if c.srv != nil {
+		return c.srv.envelope
+	}
+	return DefaultEnvelope
+}
+
+// returnData responds the data wrapped by envelope
+func (c *Context) returnData(data any) {
+	if p, ok := data.(interface{ pageLinks() []pageLink }); ok {
+		setLinkHeader(c.Writer, c.Request, p.pageLinks())
+	}
+	data, err := c.partialResponse(data)
+	if err != nil {
+		c.returnError(http.StatusInternalServerError, ResponseBody{Code: http.StatusInternalServerError, Message: err.Error()}, err)
+		return
+	}
+	c.ReturnJSON(http.StatusOK, c.envelope().Success(c.Request, data))
+}
+
+// returnError responds the error wrapped by envelope
+func (c *Context) returnError(status int, body ResponseBody, cause error) {
+	status, data := c.envelope().Error(c.Request, status, body, cause)
+	c.failed = true
+	disableHijack(c.Writer)
+	markFailed(c.Writer)
+	c.ReturnJSON(status, data)
+}
+
+type defaultEnvelope struct{}
+
+func (defaultEnvelope) Success(_ *http.Request, data any) any {
+	if _, ok := data.(ResponseBody); ok {
+		// data's type is ResponseBody, response directly
+		return data
+	}
+	if p, ok := data.(Paginated); ok {
+		info := p.PageInfo()
+		return pageResponseBody{Data: p.PageItems(), NextCursor: info.NextCursor, Total: info.Total}
+	}
+	return ResponseBody{Code: 0, Data: data}
+}
+
+// pageResponseBody is the response body of Paginated data in DefaultEnvelope
+type pageResponseBody struct {
+	Code       int    `json:"code"`
+	Data       any    `json:"data"`
+	NextCursor string `json:"next_cursor,omitempty"`
+	Total      *int64 `json:"total,omitempty"`
+}
+
+func (defaultEnvelope) Error(_ *http.Request, status int, body ResponseBody, _ error) (int, any) {
+	return status, body
+}
+
+type rawEnvelope struct{}
+
+func (rawEnvelope) Success(_ *http.Request, data any) any {
+	if rb, ok := data.(ResponseBody); ok && rb.Code == 0 {
+		return rb.Data
+	}
+	return data
+}
+
+func (rawEnvelope) Error(_ *http.Request, code int, body ResponseBody, cause error) (int, any) {
+	if code < http.StatusBadRequest {
+		if st, ok := status.FromError(cause); ok && cause != nil {
+			code = runtime.HTTPStatusFromCode(st.Code())
+		} else if body.Code >= http.StatusBadRequest && body.Code < 600 {
+			code = body.Code
+		} else {
+			code = http.StatusInternalServerError
+		}
+	}
+	return code, body
+}
+
+// responseBody is implemented by the gRPC response with `response_body` in google.api.HttpRule
+type responseBody interface {
+	XXX_ResponseBody() any
+}
+
+// gatewayResponseRewriter wraps the gRPC response by the envelope of service(WithEnvelope of route does not apply),
+// the response is marshaled by the marshaler of request in advance, so that the protojson options work on the data
+func (srv *Service) gatewayResponseRewriter(ctx context.Context, resp proto.Message) (any, error) {
+	r, ok := ctx.Value(gatewayRequestKey).(*http.Request)
+	if !ok || srv.envelope == RawEnvelope {
+		return resp, nil
+	}
+	_, marshaler := runtime.MarshalerForRequest(srv.grpc.mux, r)
+	var (
+		buf []byte
+		err error
+	)
+	if rb, ok := resp.(responseBody); ok {
+		buf, err = marshaler.Marshal(rb.XXX_ResponseBody())
+	} else {
+		buf, err = marshaler.Marshal(resp)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return srv.envelope.Success(r, json.RawMessage(buf)), nil
+}
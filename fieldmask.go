@@ -0,0 +1,149 @@
+package apix
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+)
+
+const defaultPartialResponseParam = "fields"
+
+// FieldMask is a set of field paths, the paths are the json names joined by ".", e.g: "author.name"
+type FieldMask []string
+
+// ParseFieldMask parses the comma separated paths, e.g: "id,author.name"
+func ParseFieldMask(s string) FieldMask {
+	var mask FieldMask
+	for _, p := range strings.Split(s, ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			mask = append(mask, p)
+		}
+	}
+	return mask
+}
+
+// Contains reports whether the path or any of its parents is in the mask
+func (m FieldMask) Contains(path string) bool {
+	for _, p := range m {
+		if p == path || strings.HasPrefix(path, p+".") {
+			return true
+		}
+	}
+	return false
+}
+
+// Filter return the json value of data with only the fields in mask, the masks are applied on each element of arrays.
+// The paths not existed in data are ignored.
+func (m FieldMask) Filter(data any) (any, error) {
+	buf, err := json.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+	doc, err := decodeJSONValue(buf)
+	if err != nil {
+		return nil, err
+	}
+	return m.tree().prune(doc), nil
+}
+
+// fieldTree is the trie of field paths, the nil tree means all the fields
+type fieldTree map[string]fieldTree
+
+func (m FieldMask) tree() fieldTree {
+	root := fieldTree{}
+	for _, p := range m {
+		node := root
+		names := strings.Split(p, ".")
+		for i, name := range names {
+			child, ok := node[name]
+			if ok && child == nil {
+				// the parent is selected entirely
+				break
+			}
+			if i == len(names)-1 {
+				node[name] = nil
+				break
+			}
+			if !ok {
+				child = fieldTree{}
+				node[name] = child
+			}
+			node = child
+		}
+	}
+	return root
+}
+
+func (t fieldTree) prune(v any) any {
+	if t == nil {
+		return v
+	}
+	switch value := v.(type) {
+	case map[string]any:
+		pruned := make(map[string]any, len(t))
+		for name, child := range t {
+			if fv, ok := value[name]; ok {
+				pruned[name] = child.prune(fv)
+			}
+		}
+		return pruned
+	case []any:
+		for i, elem := range value {
+			value[i] = t.prune(elem)
+		}
+		return value
+	}
+	return v
+}
+
+// decodeJSONValue decodes the json into the generic value, the numbers are decoded as json.Number to keep the precision
+func decodeJSONValue(buf []byte) (any, error) {
+	var v any
+	dec := json.NewDecoder(bytes.NewReader(buf))
+	dec.UseNumber()
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// WithPartialResponse enables the partial responses of native handlers, the fields of data returned by handlers are
+// selected by the comma separated paths in the query parameter, e.g: "?fields=id,author.name". The query parameter is
+// "fields" if param is empty. The items of Page are selected for the paginated data.
+func WithPartialResponse(param string) ServiceOption {
+	return func(srv *Service) {
+		if param == "" {
+			param = defaultPartialResponseParam
+		}
+		srv.partialResponseParam = param
+	}
+}
+
+// partialResponse selects the fields of data by the partial response parameter
+func (c *Context) partialResponse(data any) (any, error) {
+	if c.srv == nil || c.srv.partialResponseParam == "" {
+		return data, nil
+	}
+	if _, ok := data.(ResponseBody); ok {
+		return data, nil
+	}
+	mask := ParseFieldMask(c.Request.URL.Query().Get(c.srv.partialResponseParam))
+	if len(mask) == 0 {
+		return data, nil
+	}
+	p, ok := data.(Paginated)
+	if !ok {
+		return mask.Filter(data)
+	}
+	items, err := mask.Filter(p.PageItems())
+	if err != nil {
+		return nil, err
+	}
+	info := p.PageInfo()
+	page := &Page[any]{NextCursor: info.NextCursor, Total: info.Total}
+	page.Items, _ = items.([]any)
+	if page.Items == nil {
+		page.Items = []any{}
+	}
+	return page, nil
+}
@@ -0,0 +1,145 @@
+package apix
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestParseFieldMask(t *testing.T) {
+	tests := []struct {
+		s    string
+		want FieldMask
+	}{
+		{"", nil},
+		{" , ,", nil},
+		{"id", FieldMask{"id"}},
+		{"id, author.name ,", FieldMask{"id", "author.name"}},
+	}
+	for _, tt := range tests {
+		if got := ParseFieldMask(tt.s); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ParseFieldMask(%q) = %v, want %v", tt.s, got, tt.want)
+		}
+	}
+	mask := FieldMask{"id", "author"}
+	for path, want := range map[string]bool{"id": true, "author": true, "author.name": true, "authors": false, "i": false} {
+		if got := mask.Contains(path); got != want {
+			t.Errorf("Contains(%q) = %v, want %v", path, got, want)
+		}
+	}
+}
+
+func TestFieldMaskFilter(t *testing.T) {
+	type comment struct {
+		ID   int      `json:"id"`
+		Text string   `json:"text"`
+		Tags []string `json:"tags"`
+	}
+	type post struct {
+		ID       int                  `json:"id"`
+		Title    string               `json:"title"`
+		Author   map[string]any       `json:"author"`
+		Comments []comment            `json:"comments"`
+		Threads  [][]comment          `json:"threads"`
+		Groups   map[string][]comment `json:"groups"`
+	}
+	p := post{
+		ID:       1,
+		Title:    "hello",
+		Author:   map[string]any{"name": "alice", "email": "a@example.com"},
+		Comments: []comment{{ID: 1, Text: "a", Tags: []string{"x"}}, {ID: 2, Text: "b"}},
+		Threads:  [][]comment{{{ID: 3, Text: "c"}}, {}, {{ID: 4, Text: "d"}, {ID: 5, Text: "e"}}},
+		Groups:   map[string][]comment{"new": {{ID: 6, Text: "f"}}},
+	}
+	tests := []struct {
+		mask FieldMask
+		data any
+		want string
+	}{
+		{FieldMask{"id"}, p, `{"id":1}`},
+		{FieldMask{"id", "missing", "title.missing"}, p, `{"id":1,"title":"hello"}`},
+		{FieldMask{"author.name"}, p, `{"author":{"name":"alice"}}`},
+		{FieldMask{"author.name", "author"}, p, `{"author":{"name":"alice","email":"a@example.com"}}`},
+		{FieldMask{"author", "author.name"}, p, `{"author":{"name":"alice","email":"a@example.com"}}`},
+		{FieldMask{"comments.id"}, p, `{"comments":[{"id":1},{"id":2}]}`},
+		{FieldMask{"comments.tags"}, p, `{"comments":[{"tags":["x"]},{"tags":null}]}`},
+		{FieldMask{"threads.id"}, p, `{"threads":[[{"id":3}],[],[{"id":4},{"id":5}]]}`},
+		{FieldMask{"groups.new.text"}, p, `{"groups":{"new":[{"text":"f"}]}}`},
+		{FieldMask{"id"}, []post{p, {ID: 2}}, `[{"id":1},{"id":2}]`},
+		{FieldMask{"id"}, [][]post{{p}, {{ID: 2}}}, `[[{"id":1}],[{"id":2}]]`},
+		{FieldMask{"id"}, "text", `"text"`},
+		{FieldMask{"id"}, nil, `null`},
+		{nil, p, `{}`},
+	}
+	for _, tt := range tests {
+		got, err := tt.mask.Filter(tt.data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertJSONEqual(t, got, tt.want)
+	}
+}
+
+type partialUser struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+	Age  int    `json:"age"`
+}
+
+type getPartialUser struct{}
+
+func (h *getPartialUser) Execute(ctx *Context) (any, error) {
+	return partialUser{ID: 1, Name: "alice", Age: 30}, nil
+}
+
+type listPartialUsers struct {
+	OffsetPagination
+}
+
+func (h *listPartialUsers) Execute(ctx *Context) (any, error) {
+	users := []partialUser{{ID: 1, Name: "alice", Age: 30}, {ID: 2, Name: "bob", Age: 20}}
+	return NewOffsetPage(users, h.OffsetPagination, 5), nil
+}
+
+type listEmptyUsers struct{}
+
+func (h *listEmptyUsers) Execute(ctx *Context) (any, error) {
+	return NewOffsetPage[partialUser](nil, OffsetPagination{}, 0), nil
+}
+
+func TestPartialResponse(t *testing.T) {
+	tests := []struct {
+		name   string
+		opts   []ServiceOption
+		target string
+		want   string
+		link   string
+	}{
+		{"disabled", nil, "/user?fields=id", `{"code":0,"data":{"id":1,"name":"alice","age":30}}`, ""},
+		{"no fields", []ServiceOption{WithPartialResponse("")}, "/user", `{"code":0,"data":{"id":1,"name":"alice","age":30}}`, ""},
+		{"fields", []ServiceOption{WithPartialResponse("")}, "/user?fields=id,name", `{"code":0,"data":{"id":1,"name":"alice"}}`, ""},
+		{"custom param", []ServiceOption{WithPartialResponse("select")}, "/user?select=age&fields=id", `{"code":0,"data":{"age":30}}`, ""},
+		{"page", []ServiceOption{WithPartialResponse("")}, "/users?fields=name&size=2",
+			`{"code":0,"data":[{"name":"alice"},{"name":"bob"}],"total":5}`,
+			`</users?fields=name&page=1&size=2>; rel="first", </users?fields=name&page=2&size=2>; rel="next", </users?fields=name&page=3&size=2>; rel="last"`},
+		{"page without fields", []ServiceOption{WithPartialResponse("")}, "/users?size=2&page=3",
+			`{"code":0,"data":[{"id":1,"name":"alice","age":30},{"id":2,"name":"bob","age":20}],"total":5}`,
+			`</users?page=1&size=2>; rel="first", </users?page=2&size=2>; rel="prev", </users?page=3&size=2>; rel="last"`},
+		{"empty page", []ServiceOption{WithPartialResponse("")}, "/empty?fields=id", `{"code":0,"data":[],"total":0}`, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := New(tt.opts...)
+			srv.GET("/user", &getPartialUser{})
+			srv.GET("/users", &listPartialUsers{})
+			srv.GET("/empty", &listEmptyUsers{})
+			w := httptest.NewRecorder()
+			srv.ServeHTTP(w, httptest.NewRequest("GET", tt.target, nil))
+			assertJSONEqual(t, json.RawMessage(w.Body.Bytes()), tt.want)
+			if link := w.Header().Get("Link"); link != tt.link {
+				t.Errorf("got Link %q, want %q", link, tt.link)
+			}
+		})
+	}
+}
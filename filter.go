@@ -0,0 +1,779 @@
+package apix
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// FilterType is the value type of filterable field, the literals in filter are converted into it
+type FilterType int
+
+const (
+	FilterString   FilterType = iota + 1 // string, the proto enums are compared by name
+	FilterInt                            // int64
+	FilterFloat                          // float64
+	FilterBool                           // bool
+	FilterTime                           // time.Time, the literal is RFC3339 or date(2006-01-02)
+	FilterDuration                       // time.Duration, the literal is parsed by time.ParseDuration
+)
+
+// FilterOperator is the comparator of restriction
+type FilterOperator string
+
+const (
+	FilterEQ  FilterOperator = "="
+	FilterNE  FilterOperator = "!="
+	FilterLT  FilterOperator = "<"
+	FilterLE  FilterOperator = "<="
+	FilterGT  FilterOperator = ">"
+	FilterGE  FilterOperator = ">="
+	FilterHas FilterOperator = ":" // contains the element for repeated fields, or presence with the "*" value
+)
+
+// FilterField is a field allowed in filter and order_by
+type FilterField struct {
+	Path     string // path in filter, e.g: "author.name"
+	Column   string // column name in SQL
+	Type     FilterType
+	Repeated bool
+
+	index []int                          // index path of struct field
+	fds   []protoreflect.FieldDescriptor // descriptor path of proto field
+	enum  protoreflect.EnumDescriptor
+}
+
+// FilterExpr is the AST node of filter, it's one of FilterAnd, FilterOr, FilterNot and *FilterRestriction
+type FilterExpr interface {
+	filterExpr()
+}
+
+// FilterAnd matches if all the expressions match
+type FilterAnd []FilterExpr
+
+// FilterOr matches if any of the expressions matches
+type FilterOr []FilterExpr
+
+// FilterNot negates the expression
+type FilterNot struct {
+	Expr FilterExpr
+}
+
+// FilterRestriction compares the field with value, value is typed by the FilterType of field,
+// it's nil for the null literal, and FilterPresence for "field:*"
+type FilterRestriction struct {
+	Field    *FilterField
+	Operator FilterOperator
+	Value    any
+}
+
+func (FilterAnd) filterExpr()          {}
+func (FilterOr) filterExpr()           {}
+func (FilterNot) filterExpr()          {}
+func (*FilterRestriction) filterExpr() {}
+
+type filterPresence struct{}
+
+// FilterPresence is the value of "field:*" restriction
+var FilterPresence any = filterPresence{}
+
+// OrderBy is a field ordering parsed from order_by
+type OrderBy struct {
+	Field *FilterField
+	Desc  bool
+}
+
+// FilterSchema is the allow-list of fields in filter and order_by
+type FilterSchema struct {
+	mu      sync.RWMutex
+	fields  map[string]*FilterField
+	message protoreflect.MessageDescriptor // the fields of message are resolved lazily
+	only    map[string]bool
+	columns map[string]string
+}
+
+// NewFilterSchema create the schema by the fields, the fields are matched against the struct or proto message by
+// path in the predicate evaluation.
+func NewFilterSchema(fields ...FilterField) *FilterSchema {
+	s := &FilterSchema{fields: make(map[string]*FilterField, len(fields))}
+	for i := range fields {
+		f := fields[i]
+		if f.Column == "" {
+			f.Column = f.Path
+		}
+		s.fields[f.Path] = &f
+	}
+	return s
+}
+
+var filterSchemas sync.Map // reflect.Type -> *FilterSchema
+
+// FilterSchemaOf derive the schema from the struct type of v. The field path is named by json tag(or field name),
+// and the column is named by db tag(or path). The nested structs are joined by ".", and the fields tagged by `filter:"-"`
+// are excluded.
+func FilterSchemaOf(v any) *FilterSchema {
+	t := reflect.TypeOf(v)
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if s, ok := filterSchemas.Load(t); ok {
+		return s.(*FilterSchema)
+	}
+	s := &FilterSchema{fields: make(map[string]*FilterField)}
+	collectStructFilterFields(s, t, nil, "", "", map[reflect.Type]bool{})
+	actual, _ := filterSchemas.LoadOrStore(t, s)
+	return actual.(*FilterSchema)
+}
+
+var (
+	timeType     = reflect.TypeOf(time.Time{})
+	durationType = reflect.TypeOf(time.Duration(0))
+)
+
+func collectStructFilterFields(s *FilterSchema, t reflect.Type, index []int, path, column string, visiting map[reflect.Type]bool) {
+	if visiting[t] {
+		return
+	}
+	visiting[t] = true
+	defer delete(visiting, t)
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		if !sf.IsExported() || sf.Tag.Get("filter") == "-" {
+			continue
+		}
+		name, _, _ := strings.Cut(sf.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		col, _, _ := strings.Cut(sf.Tag.Get("db"), ",")
+		if col == "-" {
+			continue
+		}
+		ft := sf.Type
+		for ft.Kind() == reflect.Pointer {
+			ft = ft.Elem()
+		}
+		idx := append(append([]int(nil), index...), i)
+		if sf.Anonymous && name == "" && ft.Kind() == reflect.Struct && ft != timeType {
+			collectStructFilterFields(s, ft, idx, path, column, visiting)
+			continue
+		}
+		if name == "" {
+			name = sf.Name
+		}
+		if col == "" {
+			col = name
+		}
+		fpath, fcol := joinFilterPath(path, name), joinFilterPath(column, col)
+		repeated := false
+		if ft.Kind() == reflect.Slice && ft.Elem().Kind() != reflect.Uint8 {
+			repeated, ft = true, ft.Elem()
+			for ft.Kind() == reflect.Pointer {
+				ft = ft.Elem()
+			}
+		}
+		if typ, ok := filterTypeOf(ft); ok {
+			s.fields[fpath] = &FilterField{Path: fpath, Column: fcol, Type: typ, Repeated: repeated, index: idx}
+		} else if ft.Kind() == reflect.Struct && !repeated {
+			collectStructFilterFields(s, ft, idx, fpath, fcol, visiting)
+		}
+	}
+}
+
+func joinFilterPath(prefix, name string) string {
+	if prefix == "" {
+		return name
+	}
+	return prefix + "." + name
+}
+
+func filterTypeOf(t reflect.Type) (FilterType, bool) {
+	switch {
+	case t == timeType:
+		return FilterTime, true
+	case t == durationType:
+		return FilterDuration, true
+	}
+	switch t.Kind() {
+	case reflect.String:
+		return FilterString, true
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return FilterInt, true
+	case reflect.Float32, reflect.Float64:
+		return FilterFloat, true
+	case reflect.Bool:
+		return FilterBool, true
+	}
+	return 0, false
+}
+
+// FilterSchemaOfMessage derive the schema from the proto message, the fields are looked up by the proto name or json name
+// like the gateway query parameters, and the column is the proto name path.
+func FilterSchemaOfMessage(msg proto.Message) *FilterSchema {
+	return &FilterSchema{
+		fields:  make(map[string]*FilterField),
+		message: msg.ProtoReflect().Descriptor(),
+	}
+}
+
+// Only return a copy of schema whose allowed fields are restricted to paths
+func (s *FilterSchema) Only(paths ...string) *FilterSchema {
+	c := s.clone()
+	c.only = make(map[string]bool, len(paths))
+	for _, p := range paths {
+		c.only[p] = true
+	}
+	return c
+}
+
+// Columns return a copy of schema whose column names of the field paths are overridden
+func (s *FilterSchema) Columns(columns map[string]string) *FilterSchema {
+	c := s.clone()
+	c.columns = columns
+	return c
+}
+
+func (s *FilterSchema) clone() *FilterSchema {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	c := &FilterSchema{fields: make(map[string]*FilterField, len(s.fields)), message: s.message, only: s.only, columns: s.columns}
+	for k, v := range s.fields {
+		c.fields[k] = v
+	}
+	return c
+}
+
+// Field return the allowed field of path
+func (s *FilterSchema) Field(path string) (*FilterField, bool) {
+	if s.only != nil && !s.only[path] {
+		return nil, false
+	}
+	s.mu.RLock()
+	f, ok := s.fields[path]
+	s.mu.RUnlock()
+	if !ok && s.message != nil {
+		if f, ok = resolveMessageFilterField(s.message, path); ok {
+			s.mu.Lock()
+			s.fields[path] = f
+			s.mu.Unlock()
+		}
+	}
+	if !ok {
+		return nil, false
+	}
+	if col, renamed := s.columns[path]; renamed && col != f.Column {
+		clone := *f
+		clone.Column = col
+		f = &clone
+	}
+	return f, true
+}
+
+// resolveMessageFilterField walks the message descriptor by the field path
+func resolveMessageFilterField(md protoreflect.MessageDescriptor, path string) (*FilterField, bool) {
+	var (
+		fds   []protoreflect.FieldDescriptor
+		names []string
+	)
+	for _, name := range strings.Split(path, ".") {
+		if md == nil {
+			return nil, false
+		}
+		fd := lookupField(md.Fields(), name)
+		if fd == nil || fd.IsMap() {
+			return nil, false
+		}
+		fds = append(fds, fd)
+		names = append(names, string(fd.Name()))
+		md = nil
+		if fd.Message() != nil && !fd.IsList() {
+			if _, ok := messageFilterType(fd.Message()); !ok {
+				md = fd.Message()
+			}
+		}
+	}
+	fd := fds[len(fds)-1]
+	f := &FilterField{Path: path, Column: strings.Join(names, "."), Repeated: fd.IsList(), fds: fds}
+	switch fd.Kind() {
+	case protoreflect.StringKind:
+		f.Type = FilterString
+	case protoreflect.EnumKind:
+		f.Type, f.enum = FilterString, fd.Enum()
+	case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind,
+		protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind,
+		protoreflect.Uint32Kind, protoreflect.Fixed32Kind, protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+		f.Type = FilterInt
+	case protoreflect.FloatKind, protoreflect.DoubleKind:
+		f.Type = FilterFloat
+	case protoreflect.BoolKind:
+		f.Type = FilterBool
+	case protoreflect.MessageKind:
+		typ, ok := messageFilterType(fd.Message())
+		if !ok {
+			return nil, false
+		}
+		f.Type = typ
+	default:
+		return nil, false
+	}
+	return f, true
+}
+
+// messageFilterType return the filter type of well-known messages
+func messageFilterType(md protoreflect.MessageDescriptor) (FilterType, bool) {
+	switch md.FullName() {
+	case "google.protobuf.Timestamp":
+		return FilterTime, true
+	case "google.protobuf.Duration":
+		return FilterDuration, true
+	case "google.protobuf.StringValue":
+		return FilterString, true
+	case "google.protobuf.Int64Value", "google.protobuf.Int32Value", "google.protobuf.UInt64Value", "google.protobuf.UInt32Value":
+		return FilterInt, true
+	case "google.protobuf.DoubleValue", "google.protobuf.FloatValue":
+		return FilterFloat, true
+	case "google.protobuf.BoolValue":
+		return FilterBool, true
+	}
+	return 0, false
+}
+
+// FilterError is the error of parsing filter or order_by
+type FilterError struct {
+	Offset  int // byte offset in the input
+	Message string
+}
+
+// Error implements the error interface
+func (e *FilterError) Error() string {
+	return fmt.Sprintf("invalid filter at %d: %s", e.Offset, e.Message)
+}
+
+// ParseFilter parses the AIP-160 filter, e.g: `status = "active" AND created_at > "2024-01-01"`. The fields are validated
+// against the schema, and the values are converted into the field types. It returns nil for the empty filter.
+//
+// The supported syntax: AND, OR, NOT, "-", parentheses, the implicit AND between sequential restrictions, and the
+// comparators =, !=, <, <=, >, >= and ":". The string values in = and != may have the leading or trailing "*" wildcard.
+// Note that OR binds tighter than AND as defined by AIP-160, e.g: `a AND b OR c` means `a AND (b OR c)`.
+func (s *FilterSchema) ParseFilter(filter string) (FilterExpr, error) {
+	p := &filterParser{schema: s, lexer: filterLexer{input: filter}}
+	p.next()
+	if p.err != nil {
+		return nil, p.err
+	}
+	if p.tok.kind == tokEOF {
+		return nil, nil
+	}
+	expr, err := p.parseExpression()
+	if err != nil {
+		return nil, err
+	}
+	// the lexer error turns the token into EOF, check it before the trailing tokens
+	if p.err != nil || p.tok.kind != tokEOF {
+		return nil, p.errorf("unexpected %q", p.tok.text)
+	}
+	return expr, nil
+}
+
+// ParseOrderBy parses the AIP-132 order_by, e.g: "name desc, created_at", the fields are validated against the schema
+func (s *FilterSchema) ParseOrderBy(orderBy string) ([]OrderBy, error) {
+	var (
+		orders []OrderBy
+		seen   = map[string]bool{}
+		offset int
+	)
+	if strings.TrimSpace(orderBy) == "" {
+		return nil, nil
+	}
+	for _, part := range strings.Split(orderBy, ",") {
+		words := strings.Fields(part)
+		if len(words) == 0 || len(words) > 2 {
+			return nil, &FilterError{Offset: offset, Message: fmt.Sprintf("invalid ordering %q", strings.TrimSpace(part))}
+		}
+		field, ok := s.Field(words[0])
+		if !ok {
+			return nil, &FilterError{Offset: offset, Message: fmt.Sprintf("field %q is not allowed", words[0])}
+		}
+		if field.Repeated {
+			return nil, &FilterError{Offset: offset, Message: fmt.Sprintf("repeated field %q can not be ordered", words[0])}
+		}
+		if seen[field.Path] {
+			return nil, &FilterError{Offset: offset, Message: fmt.Sprintf("field %q is ordered more than once", words[0])}
+		}
+		seen[field.Path] = true
+		order := OrderBy{Field: field}
+		if len(words) == 2 {
+			switch strings.ToLower(words[1]) {
+			case "desc":
+				order.Desc = true
+			case "asc":
+			default:
+				return nil, &FilterError{Offset: offset, Message: fmt.Sprintf("invalid direction %q", words[1])}
+			}
+		}
+		orders = append(orders, order)
+		offset += len(part) + 1
+	}
+	return orders, nil
+}
+
+type tokenKind int
+
+const (
+	tokEOF        tokenKind = iota
+	tokText                 // unquoted text, e.g: field path, number, keyword
+	tokString               // quoted string
+	tokComparator           // =, !=, <, <=, >, >=, :
+	tokLParen
+	tokRParen
+	tokMinus // the negation prefix
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	pos  int
+}
+
+type filterLexer struct {
+	input string
+	pos   int
+}
+
+func isFilterTextRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || strings.ContainsRune("_.*-+@", r)
+}
+
+func (l *filterLexer) next() (token, error) {
+	for l.pos < len(l.input) && unicode.IsSpace(rune(l.input[l.pos])) {
+		l.pos++
+	}
+	start := l.pos
+	if l.pos >= len(l.input) {
+		return token{kind: tokEOF, pos: start}, nil
+	}
+	switch c := l.input[l.pos]; c {
+	case '(':
+		l.pos++
+		return token{kind: tokLParen, text: "(", pos: start}, nil
+	case ')':
+		l.pos++
+		return token{kind: tokRParen, text: ")", pos: start}, nil
+	case '=', ':':
+		l.pos++
+		return token{kind: tokComparator, text: string(c), pos: start}, nil
+	case '<', '>', '!':
+		l.pos++
+		if l.pos < len(l.input) && l.input[l.pos] == '=' {
+			l.pos++
+		} else if c == '!' {
+			return token{}, &FilterError{Offset: start, Message: `"!" must be followed by "="`}
+		}
+		return token{kind: tokComparator, text: l.input[start:l.pos], pos: start}, nil
+	case '-':
+		l.pos++
+		return token{kind: tokMinus, text: "-", pos: start}, nil
+	case '"', '\'':
+		var b strings.Builder
+		for l.pos++; l.pos < len(l.input); l.pos++ {
+			switch l.input[l.pos] {
+			case '\\':
+				if l.pos+1 < len(l.input) {
+					l.pos++
+					b.WriteByte(l.input[l.pos])
+				}
+			case c:
+				l.pos++
+				return token{kind: tokString, text: b.String(), pos: start}, nil
+			default:
+				b.WriteByte(l.input[l.pos])
+			}
+		}
+		return token{}, &FilterError{Offset: start, Message: "unterminated string"}
+	}
+	for l.pos < len(l.input) {
+		r, size := utf8.DecodeRuneInString(l.input[l.pos:])
+		if !isFilterTextRune(r) {
+			break
+		}
+		l.pos += size
+	}
+	if l.pos == start {
+		return token{}, &FilterError{Offset: start, Message: fmt.Sprintf("unexpected character %q", l.input[start])}
+	}
+	return token{kind: tokText, text: l.input[start:l.pos], pos: start}, nil
+}
+
+type filterParser struct {
+	schema *FilterSchema
+	lexer  filterLexer
+	tok    token
+	err    error
+}
+
+func (p *filterParser) next() {
+	if p.err != nil {
+		return
+	}
+	p.tok, p.err = p.lexer.next()
+	if p.err != nil {
+		p.tok = token{kind: tokEOF, pos: p.lexer.pos}
+	}
+}
+
+func (p *filterParser) errorf(format string, args ...any) error {
+	if p.err != nil {
+		return p.err
+	}
+	return &FilterError{Offset: p.tok.pos, Message: fmt.Sprintf(format, args...)}
+}
+
+func (p *filterParser) isKeyword(kw string) bool {
+	return p.tok.kind == tokText && p.tok.text == kw
+}
+
+// parseExpression: sequence { "AND" sequence }
+func (p *filterParser) parseExpression() (FilterExpr, error) {
+	var exprs FilterAnd
+	for {
+		expr, err := p.parseSequence()
+		if err != nil {
+			return nil, err
+		}
+		exprs = append(exprs, expr)
+		if !p.isKeyword("AND") {
+			break
+		}
+		p.next()
+	}
+	return flattenFilterAnd(exprs), nil
+}
+
+// parseSequence: factor { factor }, the sequential factors are joined by AND implicitly
+func (p *filterParser) parseSequence() (FilterExpr, error) {
+	var exprs FilterAnd
+	for {
+		expr, err := p.parseFactor()
+		if err != nil {
+			return nil, err
+		}
+		exprs = append(exprs, expr)
+		if p.tok.kind == tokEOF || p.tok.kind == tokRParen || p.isKeyword("AND") {
+			break
+		}
+	}
+	return flattenFilterAnd(exprs), nil
+}
+
+// parseFactor: term { "OR" term }
+func (p *filterParser) parseFactor() (FilterExpr, error) {
+	var exprs FilterOr
+	for {
+		expr, err := p.parseTerm()
+		if err != nil {
+			return nil, err
+		}
+		exprs = append(exprs, expr)
+		if !p.isKeyword("OR") {
+			break
+		}
+		p.next()
+	}
+	if len(exprs) == 1 {
+		return exprs[0], nil
+	}
+	return exprs, nil
+}
+
+// parseTerm: [ "NOT" | "-" ] simple
+func (p *filterParser) parseTerm() (FilterExpr, error) {
+	if p.isKeyword("NOT") || p.tok.kind == tokMinus {
+		p.next()
+		expr, err := p.parseSimple()
+		if err != nil {
+			return nil, err
+		}
+		return FilterNot{Expr: expr}, nil
+	}
+	return p.parseSimple()
+}
+
+// parseSimple: "(" expression ")" | restriction
+func (p *filterParser) parseSimple() (FilterExpr, error) {
+	if p.tok.kind == tokLParen {
+		p.next()
+		expr, err := p.parseExpression()
+		if err != nil {
+			return nil, err
+		}
+		if p.tok.kind != tokRParen {
+			return nil, p.errorf(`")" expected`)
+		}
+		p.next()
+		if p.err != nil {
+			return nil, p.err
+		}
+		return expr, nil
+	}
+	return p.parseRestriction()
+}
+
+// parseRestriction: field comparator value
+func (p *filterParser) parseRestriction() (FilterExpr, error) {
+	if p.tok.kind != tokText || p.isKeyword("AND") || p.isKeyword("OR") || p.isKeyword("NOT") {
+		return nil, p.errorf("field expected")
+	}
+	fieldTok := p.tok
+	field, ok := p.schema.Field(fieldTok.text)
+	if !ok {
+		return nil, p.errorf("field %q is not allowed", fieldTok.text)
+	}
+	p.next()
+	if p.tok.kind != tokComparator {
+		return nil, p.errorf("comparator expected after %q", fieldTok.text)
+	}
+	op := FilterOperator(p.tok.text)
+	p.next()
+	valueTok := p.tok
+	if valueTok.kind == tokMinus {
+		// negative number
+		p.next()
+		if p.tok.kind != tokText || p.tok.pos != valueTok.pos+1 {
+			return nil, p.errorf("value expected")
+		}
+		valueTok = token{kind: tokText, text: "-" + p.tok.text, pos: valueTok.pos}
+	} else if valueTok.kind != tokText && valueTok.kind != tokString {
+		return nil, p.errorf("value expected")
+	}
+	p.next()
+	if p.err != nil {
+		return nil, p.err
+	}
+	value, err := filterValue(field, op, valueTok)
+	if err != nil {
+		return nil, &FilterError{Offset: valueTok.pos, Message: err.Error()}
+	}
+	return &FilterRestriction{Field: field, Operator: op, Value: value}, nil
+}
+
+func flattenFilterAnd(exprs FilterAnd) FilterExpr {
+	if len(exprs) == 1 {
+		return exprs[0]
+	}
+	var flat FilterAnd
+	for _, expr := range exprs {
+		if and, ok := expr.(FilterAnd); ok {
+			flat = append(flat, and...)
+		} else {
+			flat = append(flat, expr)
+		}
+	}
+	return flat
+}
+
+// filterValue converts the literal into the type of field, and checks the operator
+func filterValue(field *FilterField, op FilterOperator, tok token) (any, error) {
+	if tok.kind == tokText && tok.text == "*" {
+		if op != FilterHas {
+			return nil, errors.New(`"*" is only allowed in presence restriction "field:*"`)
+		}
+		return FilterPresence, nil
+	}
+	if tok.kind == tokText && tok.text == "null" {
+		if op != FilterEQ && op != FilterNE {
+			return nil, fmt.Errorf("null can not be compared by %q", op)
+		}
+		return nil, nil
+	}
+	switch {
+	case field.Repeated && op != FilterHas:
+		return nil, fmt.Errorf("repeated field %q only supports the \":\" comparator", field.Path)
+	case !field.Repeated && op == FilterHas:
+		return nil, fmt.Errorf("field %q only supports the \":*\" presence restriction", field.Path)
+	case field.Type == FilterBool && op != FilterEQ && op != FilterNE && op != FilterHas:
+		return nil, fmt.Errorf("bool field %q can not be compared by %q", field.Path, op)
+	}
+	text := tok.text
+	switch field.Type {
+	case FilterString:
+		if field.enum != nil && field.enum.Values().ByName(protoreflect.Name(text)) == nil {
+			return nil, fmt.Errorf("%q is not a valid value of %q", text, field.Path)
+		}
+		return text, nil
+	case FilterInt:
+		v, err := strconv.ParseInt(text, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not an integer", text)
+		}
+		return v, nil
+	case FilterFloat:
+		v, err := strconv.ParseFloat(text, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not a number", text)
+		}
+		return v, nil
+	case FilterBool:
+		v, err := strconv.ParseBool(text)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not a bool", text)
+		}
+		return v, nil
+	case FilterTime:
+		if t, err := time.Parse(time.RFC3339Nano, text); err == nil {
+			return t, nil
+		}
+		t, err := time.Parse(time.DateOnly, text)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not a RFC3339 time or date", text)
+		}
+		return t, nil
+	case FilterDuration:
+		v, err := time.ParseDuration(text)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not a duration", text)
+		}
+		return v, nil
+	}
+	return nil, fmt.Errorf("field %q is not filterable", field.Path)
+}
+
+// Filtering is the filter and order_by parameters, embed it into the handler struct, the parameters are parsed and
+// validated against the schema derived from T by FilterSchemaOf after binding.
+type Filtering[T any] struct {
+	Filter  string `query:"filter"`
+	OrderBy string `query:"order_by"`
+
+	expr   FilterExpr
+	orders []OrderBy
+}
+
+// normalizeParams parses the filter and order_by
+func (f *Filtering[T]) normalizeParams(ctx *Context) error {
+	var (
+		schema = FilterSchemaOf(new(T))
+		err    error
+	)
+	if f.expr, err = schema.ParseFilter(f.Filter); err != nil {
+		return fmt.Errorf("filter: %w", err)
+	}
+	if f.orders, err = schema.ParseOrderBy(f.OrderBy); err != nil {
+		return fmt.Errorf("order_by: %w", err)
+	}
+	return nil
+}
+
+// Expr return the parsed filter, it's nil if no filter
+func (f *Filtering[T]) Expr() FilterExpr { return f.expr }
+
+// Orders return the parsed order_by
+func (f *Filtering[T]) Orders() []OrderBy { return f.orders }
@@ -0,0 +1,389 @@
+package apix
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+type filterItem struct {
+	ID     int      `json:"id"`
+	Name   string   `json:"name"`
+	Age    int      `json:"age"`
+	Score  float64  `json:"score"`
+	Active bool     `json:"active"`
+	Nick   *string  `json:"nick" db:"nick_name"`
+	Tags   []string `json:"tags"`
+	Secret string   `json:"-"`
+}
+
+func ptr[T any](v T) *T { return &v }
+
+var filterItems = []filterItem{
+	{ID: 1, Name: "alice", Age: 30, Score: 1.5, Active: true, Nick: ptr("al"), Tags: []string{"go", "sql"}},
+	{ID: 2, Name: "bob", Age: -5, Score: -2, Active: false, Tags: []string{"go"}},
+	{ID: 3, Name: "10%_off", Age: 18, Score: 0, Active: true, Nick: ptr("")},
+	{ID: 4, Name: `a\b`, Age: 0, Active: false, Nick: ptr("x")},
+}
+
+func TestParseFilter(t *testing.T) {
+	schema := FilterSchemaOf(filterItem{})
+	tests := []struct {
+		filter string
+		sql    string
+		args   []any
+	}{
+		{"", "", nil},
+		{"   ", "", nil},
+		{`name = "alice"`, "name = $1", []any{"alice"}},
+		{`name = 'alice'`, "name = $1", []any{"alice"}},
+		{`name = alice`, "name = $1", []any{"alice"}},
+		{`name = "a\"b"`, "name = $1", []any{`a"b`}},
+		{"age >= 18", "age >= $1", []any{int64(18)}},
+		{"score < 1.5", "score < $1", []any{1.5}},
+		{"active = true", "active = $1", []any{true}},
+		// OR binds tighter than AND
+		{"age = 1 AND age = 2 OR age = 3", "(age = $1 AND (age = $2 OR age = $3))", []any{int64(1), int64(2), int64(3)}},
+		{"age = 1 OR age = 2 AND age = 3", "((age = $1 OR age = $2) AND age = $3)", []any{int64(1), int64(2), int64(3)}},
+		{"(age = 1 AND age = 2) OR age = 3", "((age = $1 AND age = $2) OR age = $3)", []any{int64(1), int64(2), int64(3)}},
+		// the sequential restrictions are joined by AND, and flattened
+		{"age = 1 age = 2 AND age = 3", "(age = $1 AND age = $2 AND age = $3)", []any{int64(1), int64(2), int64(3)}},
+		{"NOT active = true", "NOT (active = $1)", []any{true}},
+		{"-active = true", "NOT (active = $1)", []any{true}},
+		{"-(age = 1 OR age = 2)", "NOT ((age = $1 OR age = $2))", []any{int64(1), int64(2)}},
+		// negative numbers
+		{"age = -5", "age = $1", []any{int64(-5)}},
+		{"age > -5 AND score <= -2.5", "(age > $1 AND score <= $2)", []any{int64(-5), -2.5}},
+		{"age=-5", "age = $1", []any{int64(-5)}},
+		// wildcards and LIKE escaping
+		{`name = "ali*"`, `name LIKE $1 ESCAPE '\'`, []any{"ali%"}},
+		{`name = "*ice"`, `name LIKE $1 ESCAPE '\'`, []any{"%ice"}},
+		{`name != "*li*"`, `name NOT LIKE $1 ESCAPE '\'`, []any{"%li%"}},
+		{`name = "*10%_off*"`, `name LIKE $1 ESCAPE '\'`, []any{`%10\%\_off%`}},
+		{`name = "*a\\b"`, `name LIKE $1 ESCAPE '\'`, []any{`%a\\b`}},
+		{`name = "*"`, `name LIKE $1 ESCAPE '\'`, []any{"%"}},
+		{`name = "a*b"`, "name = $1", []any{"a*b"}},
+		// null and presence
+		{"nick = null", "nick_name IS NULL", nil},
+		{"nick != null", "nick_name IS NOT NULL", nil},
+		{"nick:*", "nick_name IS NOT NULL", nil},
+		{"tags:*", "tags IS NOT NULL", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.filter, func(t *testing.T) {
+			expr, err := schema.ParseFilter(tt.filter)
+			if err != nil {
+				t.Fatalf("ParseFilter: %v", err)
+			}
+			sql, args, err := FilterSQL(expr, DollarPlaceholder)
+			if err != nil {
+				t.Fatalf("FilterSQL: %v", err)
+			}
+			if sql != tt.sql || !reflect.DeepEqual(args, tt.args) {
+				t.Errorf("got %q %#v, want %q %#v", sql, args, tt.sql, tt.args)
+			}
+		})
+	}
+}
+
+func TestParseFilterErrors(t *testing.T) {
+	schema := FilterSchemaOf(filterItem{})
+	tests := []struct {
+		filter string
+		offset int
+	}{
+		// lexer errors
+		{`"abc`, 0},
+		{"!", 0},
+		{"#", 0},
+		{"(age = 1) !", 10},
+		{"(age = 1) #", 10},
+		{"age = 1 #", 8},
+		{`age = 1 AND "x`, 12},
+		{"age ! 1", 4},
+		{`name = "abc`, 7},
+		// parser errors
+		{"age", 3},
+		{"age =", 5},
+		{"= 1", 0},
+		{"AND age = 1", 0},
+		{"age = 1 AND", 11},
+		{"(age = 1", 8},
+		{"age = 1)", 7},
+		{"()", 1},
+		{"secret = 1", 0},
+		{"unknown = 1", 0},
+		{"age = - 1", 8},
+		// value errors
+		{`age = "x"`, 6},
+		{"score = x", 8},
+		{"active > true", 9},
+		{"age = *", 6},
+		{"age < null", 6},
+		{"age:*1", 4},
+		{"tags = go", 7},
+		{"name:x", 5},
+	}
+	for _, tt := range tests {
+		t.Run(tt.filter, func(t *testing.T) {
+			expr, err := schema.ParseFilter(tt.filter)
+			var fe *FilterError
+			if !errors.As(err, &fe) {
+				t.Fatalf("got %v %v, want FilterError", expr, err)
+			}
+			if fe.Offset != tt.offset {
+				t.Errorf("got offset %d(%s), want %d", fe.Offset, fe.Message, tt.offset)
+			}
+		})
+	}
+}
+
+func TestFilterSQLPlaceholder(t *testing.T) {
+	schema := FilterSchemaOf(filterItem{})
+	expr, err := schema.ParseFilter(`name = "a" OR age > 1 AND nick = null AND score != 2`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		placeholder SQLPlaceholder
+		want        string
+	}{
+		{nil, "((name = ? OR age > ?) AND nick_name IS NULL AND score != ?)"},
+		{QuestionPlaceholder, "((name = ? OR age > ?) AND nick_name IS NULL AND score != ?)"},
+		{DollarPlaceholder, "((name = $1 OR age > $2) AND nick_name IS NULL AND score != $3)"},
+	}
+	for _, tt := range tests {
+		sql, args, err := FilterSQL(expr, tt.placeholder)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if sql != tt.want || !reflect.DeepEqual(args, []any{"a", int64(1), 2.0}) {
+			t.Errorf("got %q %#v, want %q", sql, args, tt.want)
+		}
+	}
+	if sql, args, err := FilterSQL(nil, DollarPlaceholder); sql != "" || args != nil || err != nil {
+		t.Errorf("got %q %v %v for nil filter", sql, args, err)
+	}
+	expr, _ = schema.ParseFilter("tags:go")
+	if _, _, err := FilterSQL(expr, nil); err == nil {
+		t.Error("want error for the repeated field")
+	}
+}
+
+func TestMatchFilter(t *testing.T) {
+	schema := FilterSchemaOf(filterItem{})
+	tests := []struct {
+		filter string
+		ids    []int
+	}{
+		{"", []int{1, 2, 3, 4}},
+		{`name = "alice"`, []int{1}},
+		{"age = 1 AND age = 2 OR age = 30", nil},
+		{"age = 30 OR age = 18 AND active = true", []int{1, 3}},
+		{"age = 30 OR age = -5 AND active = false", []int{2}},
+		{"age < 0", []int{2}},
+		{"age >= -5 score < 0", []int{2}},
+		{`name = "*o*"`, []int{2, 3}},
+		{`name = "10%_*"`, []int{3}},
+		{`name = "1%*"`, nil},
+		{`name = "*\\b"`, []int{4}},
+		{`name != "a*"`, []int{2, 3}},
+		{"nick = null", []int{2}},
+		{"nick != null", []int{1, 3, 4}},
+		{"nick:*", []int{1, 3, 4}},
+		{`nick = ""`, []int{3}},
+		// the comparisons with null are unknown as SQL
+		{`nick != "al"`, []int{3, 4}},
+		{`NOT nick = "al"`, []int{3, 4}},
+		{`NOT (nick = "al" AND age = 30)`, []int{2, 3, 4}},
+		{`nick = "al" OR age = -5`, []int{1, 2}},
+		{"tags:*", []int{1, 2}},
+		{"tags:go", []int{1, 2}},
+		{"tags:sql", []int{1}},
+		{"-tags:sql", []int{2, 3, 4}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.filter, func(t *testing.T) {
+			expr, err := schema.ParseFilter(tt.filter)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var ids []int
+			for _, item := range FilterSlice(filterItems, expr) {
+				ids = append(ids, item.ID)
+			}
+			if !reflect.DeepEqual(ids, tt.ids) {
+				t.Errorf("got %v, want %v", ids, tt.ids)
+			}
+			if strings.Contains(tt.filter, "tags") {
+				return
+			}
+			sql, args, err := FilterSQL(expr, DollarPlaceholder)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, item := range filterItems {
+				row := make(map[string]any)
+				for _, f := range schema.fields {
+					row[f.Column] = filterFieldValue(f, item)
+				}
+				if got, want := evalSQL(t, sql, args, row), MatchFilter(expr, item); got != want {
+					t.Errorf("item %d: %s is %v, MatchFilter is %v", item.ID, sql, got, want)
+				}
+			}
+		})
+	}
+}
+
+// evalSQL evaluates the WHERE clause generated by FilterSQL on the row by the three-valued logic of SQL
+func evalSQL(t *testing.T, sql string, args []any, row map[string]any) bool {
+	t.Helper()
+	if sql == "" {
+		return true
+	}
+	e := &sqlEval{tokens: strings.Fields(strings.NewReplacer("(", " ( ", ")", " ) ").Replace(sql)), args: args, row: row}
+	v, err := e.expr()
+	if err == nil && e.pos != len(e.tokens) {
+		err = fmt.Errorf("unexpected %q", e.tokens[e.pos])
+	}
+	if err != nil {
+		t.Fatalf("eval %s: %v", sql, err)
+	}
+	return v != nil && *v
+}
+
+type sqlEval struct {
+	tokens []string
+	pos    int
+	args   []any
+	row    map[string]any
+}
+
+func (e *sqlEval) next() string {
+	if e.pos >= len(e.tokens) {
+		return ""
+	}
+	e.pos++
+	return e.tokens[e.pos-1]
+}
+
+func (e *sqlEval) peek() string {
+	if e.pos >= len(e.tokens) {
+		return ""
+	}
+	return e.tokens[e.pos]
+}
+
+func (e *sqlEval) arg(token string) (any, error) {
+	n, err := strconv.Atoi(strings.TrimPrefix(token, "$"))
+	if err != nil || n < 1 || n > len(e.args) {
+		return nil, fmt.Errorf("invalid placeholder %q", token)
+	}
+	return e.args[n-1], nil
+}
+
+// expr returns nil for the unknown
+func (e *sqlEval) expr() (*bool, error) {
+	switch tok := e.next(); tok {
+	case "NOT":
+		v, err := e.expr()
+		if v != nil {
+			v = ptr(!*v)
+		}
+		return v, err
+	case "(":
+		v, err := e.expr()
+		for err == nil && (e.peek() == "AND" || e.peek() == "OR") {
+			op := e.next()
+			var w *bool
+			if w, err = e.expr(); err != nil {
+				break
+			}
+			switch {
+			case op == "AND" && (v != nil && !*v || w != nil && !*w):
+				v = ptr(false)
+			case op == "OR" && (v != nil && *v || w != nil && *w):
+				v = ptr(true)
+			case v == nil || w == nil:
+				v = nil
+			}
+		}
+		if err == nil && e.next() != ")" {
+			err = fmt.Errorf(`")" expected`)
+		}
+		return v, err
+	default:
+		value, ok := e.row[tok]
+		if !ok {
+			return nil, fmt.Errorf("unknown column %q", tok)
+		}
+		op := e.next()
+		if op == "IS" {
+			if e.peek() == "NOT" {
+				e.next()
+				e.next()
+				return ptr(value != nil), nil
+			}
+			e.next()
+			return ptr(value == nil), nil
+		}
+		if op == "NOT" {
+			op += " " + e.next()
+		}
+		target, err := e.arg(e.next())
+		if err != nil {
+			return nil, err
+		}
+		if value == nil {
+			return nil, nil
+		}
+		switch op {
+		case "LIKE", "NOT LIKE":
+			if e.next() != "ESCAPE" || e.next() != `'\'` {
+				return nil, fmt.Errorf("ESCAPE expected")
+			}
+			v := likeRegexp(target.(string)).MatchString(value.(string))
+			return ptr(v == (op == "LIKE")), nil
+		}
+		c := compareFilterValues(value, target)
+		switch FilterOperator(op) {
+		case FilterEQ:
+			return ptr(c == 0), nil
+		case FilterNE:
+			return ptr(c != 0), nil
+		case FilterLT:
+			return ptr(c < 0), nil
+		case FilterLE:
+			return ptr(c <= 0), nil
+		case FilterGT:
+			return ptr(c > 0), nil
+		case FilterGE:
+			return ptr(c >= 0), nil
+		}
+		return nil, fmt.Errorf("unknown operator %q", op)
+	}
+}
+
+func likeRegexp(pattern string) *regexp.Regexp {
+	var b strings.Builder
+	b.WriteString("^")
+	for i := 0; i < len(pattern); i++ {
+		switch c := pattern[i]; c {
+		case '\\':
+			i++
+			b.WriteString(regexp.QuoteMeta(pattern[i : i+1]))
+		case '%':
+			b.WriteString(".*")
+		case '_':
+			b.WriteString(".")
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	b.WriteString("$")
+	return regexp.MustCompile(b.String())
+}
@@ -0,0 +1,440 @@
+package apix
+
+import (
+	"cmp"
+	"fmt"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// SQLPlaceholder return the placeholder of the nth(start from 1) argument
+type SQLPlaceholder func(n int) string
+
+var (
+	// QuestionPlaceholder is the "?" placeholder of MySQL and SQLite
+	QuestionPlaceholder SQLPlaceholder = func(int) string { return "?" }
+	// DollarPlaceholder is the "$1" placeholder of PostgreSQL
+	DollarPlaceholder SQLPlaceholder = func(n int) string { return "$" + strconv.Itoa(n) }
+)
+
+// FilterSQL translates the filter into the SQL WHERE clause(without "WHERE") and its arguments, the columns are taken
+// from the schema, and the values are always passed by arguments. It returns empty clause for the nil filter.
+// The string wildcards are translated into LIKE, and the restrictions of repeated fields are not supported.
+func FilterSQL(expr FilterExpr, placeholder SQLPlaceholder) (string, []any, error) {
+	if expr == nil {
+		return "", nil, nil
+	}
+	if placeholder == nil {
+		placeholder = QuestionPlaceholder
+	}
+	t := &sqlTranslator{placeholder: placeholder}
+	if err := t.translate(expr); err != nil {
+		return "", nil, err
+	}
+	return t.b.String(), t.args, nil
+}
+
+// OrderBySQL translates the orders into the SQL ORDER BY clause(without "ORDER BY")
+func OrderBySQL(orders []OrderBy) string {
+	parts := make([]string, 0, len(orders))
+	for _, o := range orders {
+		if o.Desc {
+			parts = append(parts, o.Field.Column+" DESC")
+		} else {
+			parts = append(parts, o.Field.Column)
+		}
+	}
+	return strings.Join(parts, ", ")
+}
+
+type sqlTranslator struct {
+	b           strings.Builder
+	args        []any
+	placeholder SQLPlaceholder
+}
+
+func (t *sqlTranslator) arg(v any) string {
+	t.args = append(t.args, v)
+	return t.placeholder(len(t.args))
+}
+
+func (t *sqlTranslator) join(exprs []FilterExpr, sep string) error {
+	t.b.WriteByte('(')
+	for i, expr := range exprs {
+		if i > 0 {
+			t.b.WriteString(sep)
+		}
+		if err := t.translate(expr); err != nil {
+			return err
+		}
+	}
+	t.b.WriteByte(')')
+	return nil
+}
+
+func (t *sqlTranslator) translate(expr FilterExpr) error {
+	switch e := expr.(type) {
+	case FilterAnd:
+		return t.join(e, " AND ")
+	case FilterOr:
+		return t.join(e, " OR ")
+	case FilterNot:
+		t.b.WriteString("NOT ")
+		return t.join([]FilterExpr{e.Expr}, "")
+	case *FilterRestriction:
+		col := e.Field.Column
+		switch {
+		case e.Value == FilterPresence:
+			t.b.WriteString(col + " IS NOT NULL")
+		case e.Field.Repeated:
+			return fmt.Errorf("repeated field %q is not supported in SQL", e.Field.Path)
+		case e.Value == nil && e.Operator == FilterEQ:
+			t.b.WriteString(col + " IS NULL")
+		case e.Value == nil:
+			t.b.WriteString(col + " IS NOT NULL")
+		default:
+			if s, ok := e.Value.(string); ok && (e.Operator == FilterEQ || e.Operator == FilterNE) && hasWildcard(s) {
+				op := " LIKE "
+				if e.Operator == FilterNE {
+					op = " NOT LIKE "
+				}
+				t.b.WriteString(col + op + t.arg(likePattern(s)) + ` ESCAPE '\'`)
+				return nil
+			}
+			t.b.WriteString(col + " " + string(e.Operator) + " " + t.arg(e.Value))
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown filter expression %T", expr)
+}
+
+func hasWildcard(s string) bool {
+	return strings.HasPrefix(s, "*") || strings.HasSuffix(s, "*")
+}
+
+// likePattern converts the leading and trailing "*" wildcards into "%"
+func likePattern(s string) string {
+	prefix, suffix := strings.HasPrefix(s, "*"), strings.HasSuffix(s, "*") && len(s) > 1
+	s = strings.TrimSuffix(strings.TrimPrefix(s, "*"), "*")
+	s = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(s)
+	if prefix {
+		s = "%" + s
+	}
+	if suffix {
+		s += "%"
+	}
+	return s
+}
+
+// matchWildcard matches s by the pattern with the leading and trailing "*" wildcards
+func matchWildcard(pattern, s string) bool {
+	prefix, suffix := strings.HasPrefix(pattern, "*"), strings.HasSuffix(pattern, "*") && len(pattern) > 1
+	core := strings.TrimSuffix(strings.TrimPrefix(pattern, "*"), "*")
+	switch {
+	case prefix && suffix:
+		return strings.Contains(s, core)
+	case prefix:
+		return strings.HasSuffix(s, core)
+	case suffix:
+		return strings.HasPrefix(s, core)
+	}
+	return s == pattern
+}
+
+// MatchFilter evaluates the filter on the item, which is the struct(or pointer) that the schema derived from, or the
+// proto message. It returns true for the nil filter. The comparisons with null are unknown like SQL, e.g: both
+// `nick = "x"` and `nick != "x"` do not match the null nick, so that the result agrees with FilterSQL.
+func MatchFilter(expr FilterExpr, item any) bool {
+	return evalFilter(expr, item) == filterTrue
+}
+
+// filterResult is the three-valued logic result of SQL
+type filterResult int
+
+const (
+	filterFalse filterResult = iota
+	filterTrue
+	filterUnknown
+)
+
+func evalFilter(expr FilterExpr, item any) filterResult {
+	switch e := expr.(type) {
+	case nil:
+		return filterTrue
+	case FilterAnd:
+		result := filterTrue
+		for _, sub := range e {
+			switch evalFilter(sub, item) {
+			case filterFalse:
+				return filterFalse
+			case filterUnknown:
+				result = filterUnknown
+			}
+		}
+		return result
+	case FilterOr:
+		result := filterFalse
+		for _, sub := range e {
+			switch evalFilter(sub, item) {
+			case filterTrue:
+				return filterTrue
+			case filterUnknown:
+				result = filterUnknown
+			}
+		}
+		return result
+	case FilterNot:
+		switch evalFilter(e.Expr, item) {
+		case filterTrue:
+			return filterFalse
+		case filterFalse:
+			return filterTrue
+		}
+		return filterUnknown
+	case *FilterRestriction:
+		return matchRestriction(e, filterFieldValue(e.Field, item))
+	}
+	return filterFalse
+}
+
+// FilterSlice return the items matched by the filter
+func FilterSlice[T any](items []T, expr FilterExpr) []T {
+	var matched []T
+	for _, item := range items {
+		if MatchFilter(expr, item) {
+			matched = append(matched, item)
+		}
+	}
+	return matched
+}
+
+// SortSlice sorts the items by the orders stably, the null values are ordered first
+func SortSlice[T any](items []T, orders []OrderBy) {
+	slices.SortStableFunc(items, func(a, b T) int {
+		for _, o := range orders {
+			c := compareFilterValues(filterFieldValue(o.Field, a), filterFieldValue(o.Field, b))
+			if o.Desc {
+				c = -c
+			}
+			if c != 0 {
+				return c
+			}
+		}
+		return 0
+	})
+}
+
+func matchRestriction(r *FilterRestriction, value any) filterResult {
+	if r.Value == FilterPresence {
+		if values, ok := value.([]any); ok {
+			return filterResultOf(len(values) > 0)
+		}
+		return filterResultOf(value != nil)
+	}
+	if r.Field.Repeated {
+		values, _ := value.([]any)
+		for _, v := range values {
+			if matchComparison(FilterEQ, v, r.Value) {
+				return filterTrue
+			}
+		}
+		return filterFalse
+	}
+	if r.Value == nil {
+		// IS NULL or IS NOT NULL
+		return filterResultOf((value == nil) == (r.Operator == FilterEQ))
+	}
+	if value == nil {
+		return filterUnknown
+	}
+	return filterResultOf(matchComparison(r.Operator, value, r.Value))
+}
+
+func filterResultOf(b bool) filterResult {
+	if b {
+		return filterTrue
+	}
+	return filterFalse
+}
+
+func matchComparison(op FilterOperator, value, target any) bool {
+	if s, ok := target.(string); ok && (op == FilterEQ || op == FilterNE) && hasWildcard(s) {
+		v, _ := value.(string)
+		return matchWildcard(s, v) == (op == FilterEQ)
+	}
+	c := compareFilterValues(value, target)
+	switch op {
+	case FilterEQ:
+		return c == 0
+	case FilterNE:
+		return c != 0
+	case FilterLT:
+		return c < 0
+	case FilterLE:
+		return c <= 0
+	case FilterGT:
+		return c > 0
+	case FilterGE:
+		return c >= 0
+	}
+	return false
+}
+
+// compareFilterValues compares the values of same FilterType, nil is less than any value
+func compareFilterValues(a, b any) int {
+	switch {
+	case a == nil && b == nil:
+		return 0
+	case a == nil:
+		return -1
+	case b == nil:
+		return 1
+	}
+	switch av := a.(type) {
+	case string:
+		bv, _ := b.(string)
+		return strings.Compare(av, bv)
+	case int64:
+		bv, _ := b.(int64)
+		return cmp.Compare(av, bv)
+	case float64:
+		bv, _ := b.(float64)
+		return cmp.Compare(av, bv)
+	case bool:
+		bv, _ := b.(bool)
+		switch {
+		case av == bv:
+			return 0
+		case bv:
+			return -1
+		}
+		return 1
+	case time.Time:
+		bv, _ := b.(time.Time)
+		return av.Compare(bv)
+	case time.Duration:
+		bv, _ := b.(time.Duration)
+		return cmp.Compare(av, bv)
+	}
+	return 0
+}
+
+// filterFieldValue return the value of field in item, it's nil if absent, and []any for the repeated fields
+func filterFieldValue(f *FilterField, item any) any {
+	if msg, ok := item.(proto.Message); ok && f.fds != nil {
+		m := msg.ProtoReflect()
+		for _, fd := range f.fds[:len(f.fds)-1] {
+			if !m.Has(fd) {
+				return nil
+			}
+			m = m.Get(fd).Message()
+		}
+		fd := f.fds[len(f.fds)-1]
+		if fd.IsList() {
+			list := m.Get(fd).List()
+			values := make([]any, list.Len())
+			for i := range values {
+				values[i] = protoFilterValue(fd, list.Get(i))
+			}
+			return values
+		}
+		if fd.HasPresence() && !m.Has(fd) {
+			return nil
+		}
+		return protoFilterValue(fd, m.Get(fd))
+	}
+	v := reflect.ValueOf(item)
+	for _, i := range f.index {
+		for v.Kind() == reflect.Pointer || v.Kind() == reflect.Interface {
+			if v.IsNil() {
+				return nil
+			}
+			v = v.Elem()
+		}
+		if v.Kind() != reflect.Struct || i >= v.NumField() {
+			return nil
+		}
+		v = v.Field(i)
+	}
+	if f.Repeated {
+		if v.Kind() != reflect.Slice {
+			return nil
+		}
+		values := make([]any, v.Len())
+		for i := range values {
+			values[i] = reflectFilterValue(f.Type, v.Index(i))
+		}
+		return values
+	}
+	return reflectFilterValue(f.Type, v)
+}
+
+func reflectFilterValue(typ FilterType, v reflect.Value) any {
+	for v.Kind() == reflect.Pointer || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return nil
+		}
+		v = v.Elem()
+	}
+	switch typ {
+	case FilterString:
+		return v.String()
+	case FilterInt:
+		if v.CanInt() {
+			return v.Int()
+		}
+		return int64(v.Uint())
+	case FilterFloat:
+		return v.Float()
+	case FilterBool:
+		return v.Bool()
+	case FilterTime:
+		t, _ := v.Interface().(time.Time)
+		return t
+	case FilterDuration:
+		return time.Duration(v.Int())
+	}
+	return nil
+}
+
+func protoFilterValue(fd protoreflect.FieldDescriptor, v protoreflect.Value) any {
+	switch fd.Kind() {
+	case protoreflect.StringKind:
+		return v.String()
+	case protoreflect.EnumKind:
+		if ev := fd.Enum().Values().ByNumber(v.Enum()); ev != nil {
+			return string(ev.Name())
+		}
+		return strconv.Itoa(int(v.Enum()))
+	case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind,
+		protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind:
+		return v.Int()
+	case protoreflect.Uint32Kind, protoreflect.Fixed32Kind, protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+		return int64(v.Uint())
+	case protoreflect.FloatKind, protoreflect.DoubleKind:
+		return v.Float()
+	case protoreflect.BoolKind:
+		return v.Bool()
+	case protoreflect.MessageKind:
+		m := v.Message()
+		switch msg := m.Interface().(type) {
+		case *timestamppb.Timestamp:
+			return msg.AsTime()
+		case *durationpb.Duration:
+			return msg.AsDuration()
+		}
+		// the wrappers
+		if inner := m.Descriptor().Fields().ByName("value"); inner != nil {
+			return protoFilterValue(inner, m.Get(inner))
+		}
+	}
+	return nil
+}
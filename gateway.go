@@ -0,0 +1,148 @@
+package apix
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+// MetadataAnnotator returns the metadata forwarded to the gRPC service for the http request
+type MetadataAnnotator func(ctx context.Context, r *http.Request) metadata.MD
+
+// WithGatewayOptions appends the grpc-gateway ServeMux options, they're applied after the apix defaults and can override them
+func WithGatewayOptions(opts ...runtime.ServeMuxOption) ServiceOption {
+	return func(srv *Service) {
+		srv.gatewayOptions = append(srv.gatewayOptions, opts...)
+	}
+}
+
+// WithProtoJSON marshals the gateway requests and responses by protojson instead of encoding/json, e.g:
+//
+//	apix.WithProtoJSON(protojson.MarshalOptions{EmitUnpopulated: true, UseProtoNames: true}, protojson.UnmarshalOptions{DiscardUnknown: true})
+func WithProtoJSON(mo protojson.MarshalOptions, uo protojson.UnmarshalOptions) ServiceOption {
+	return WithGatewayOptions(runtime.WithMarshalerOption(runtime.MIMEWildcard, &runtime.JSONPb{
+		MarshalOptions:   mo,
+		UnmarshalOptions: uo,
+	}))
+}
+
+// WithOutgoingHeaderMatcher specifics the gRPC response header metadata forwarded to the http response headers,
+// by default they're forwarded with the "Grpc-Metadata-" prefix.
+func WithOutgoingHeaderMatcher(fn runtime.HeaderMatcherFunc) ServiceOption {
+	return WithGatewayOptions(runtime.WithOutgoingHeaderMatcher(fn))
+}
+
+// WithOutgoingTrailerMatcher specifics the gRPC response trailer metadata forwarded to the http response trailers,
+// by default they're forwarded with the "Grpc-Trailer-" prefix.
+func WithOutgoingTrailerMatcher(fn runtime.HeaderMatcherFunc) ServiceOption {
+	return WithGatewayOptions(runtime.WithOutgoingTrailerMatcher(fn))
+}
+
+// WithMetadataAnnotators appends the annotators adding metadata to the gRPC requests from gateway,
+// the annotators run after the service middlewares, so the values set by middlewares(e.g: principal) are available.
+func WithMetadataAnnotators(annotators ...MetadataAnnotator) ServiceOption {
+	opts := make([]runtime.ServeMuxOption, 0, len(annotators))
+	for _, annotator := range annotators {
+		opts = append(opts, runtime.WithMetadata(annotator))
+	}
+	return WithGatewayOptions(opts...)
+}
+
+// PrincipalAnnotator copies the authenticated principal into the metadata "<prefix>id", "<prefix>roles" and "<prefix>scopes",
+// the prefix is "x-principal-" if it's empty.
+func PrincipalAnnotator(prefix string) MetadataAnnotator {
+	if prefix == "" {
+		prefix = "x-principal-"
+	}
+	prefix = strings.ToLower(prefix)
+	return func(ctx context.Context, r *http.Request) metadata.MD {
+		p := PrincipalFrom(ctx)
+		if p == nil {
+			return nil
+		}
+		md := metadata.Pairs(prefix+"id", p.ID)
+		if len(p.Roles) > 0 {
+			md.Set(prefix+"roles", p.Roles...)
+		}
+		if len(p.Scopes) > 0 {
+			md.Set(prefix+"scopes", p.Scopes...)
+		}
+		return md
+	}
+}
+
+// MetadataForwarding configures the gRPC response metadata forwarded to the http response of gateway
+type MetadataForwarding struct {
+	// Headers is the allow-list of header metadata keys forwarded as http headers, wildcard supported, e.g: "x-next-cursor", "x-ratelimit-*"
+	Headers []string
+	// Trailers is the allow-list of trailer metadata keys forwarded as http trailers, the trailers are only sent
+	// if the client accepts them by "TE: trailers" header
+	Trailers []string
+	// Rename renames the metadata key prefix to the http header prefix, e.g: {"x-ratelimit-": "RateLimit-"},
+	// the key is forwarded as it is if none of the prefixes matched
+	Rename map[string]string
+	// TrailersAsHeaders forwards the trailers as http headers if the client does not accept trailers or the request failed
+	TrailersAsHeaders bool
+}
+
+// WithMetadataForwarding forwards the allowed gRPC response header and trailer metadata to the http response,
+// instead of the default "Grpc-Metadata-" and "Grpc-Trailer-" prefixed headers. It works on the error responses too.
+func WithMetadataForwarding(cfg MetadataForwarding) ServiceOption {
+	return func(srv *Service) {
+		srv.metadataForwarding = &cfg
+	}
+}
+
+// headerMatcher return the matcher of the metadata keys in allow-list, the key is renamed by prefix
+func (cfg *MetadataForwarding) headerMatcher(allowed []string) runtime.HeaderMatcherFunc {
+	return func(key string) (string, bool) {
+		key = strings.ToLower(key)
+		for _, pattern := range allowed {
+			if matchStr(strings.ToLower(pattern), key) {
+				return cfg.rename(key), true
+			}
+		}
+		return "", false
+	}
+}
+
+func (cfg *MetadataForwarding) rename(key string) string {
+	var from, to string
+	for prefix, target := range cfg.Rename {
+		if strings.HasPrefix(key, strings.ToLower(prefix)) && len(prefix) > len(from) {
+			from, to = prefix, target
+		}
+	}
+	if from == "" {
+		return key
+	}
+	return to + key[len(from):]
+}
+
+// forwardMetadata set the allowed header or trailer metadata into the response headers
+func (cfg *MetadataForwarding) forwardMetadata(ctx context.Context, w http.ResponseWriter, headers, trailers bool) {
+	md, ok := runtime.ServerMetadataFromContext(ctx)
+	if !ok {
+		return
+	}
+	header := w.Header()
+	forward := func(md metadata.MD, matcher runtime.HeaderMatcherFunc) {
+		for k, vs := range md {
+			if h, ok := matcher(k); ok {
+				for _, v := range vs {
+					header.Add(h, v)
+				}
+			}
+		}
+	}
+	if headers {
+		forward(md.HeaderMD, cfg.headerMatcher(cfg.Headers))
+	}
+	if trailers {
+		forward(md.TrailerMD, cfg.headerMatcher(cfg.Trailers))
+	}
+}
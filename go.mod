@@ -3,9 +3,14 @@ module github.com/cloudfly/apix
 go 1.22.9
 
 require (
+	github.com/andybalholm/brotli v1.1.1
 	github.com/bytedance/go-tagexpr/v2 v2.9.11
+	github.com/cloudfly/timex v0.4.8
 	github.com/grpc-ecosystem/grpc-gateway/v2 v2.24.0
+	github.com/klauspost/compress v1.17.11
 	github.com/rs/zerolog v1.33.0
+	golang.org/x/net v0.30.0
+	golang.org/x/text v0.20.0
 	google.golang.org/genproto v0.0.0-20241118233622-e639e219e697
 	google.golang.org/grpc v1.68.0
 	google.golang.org/protobuf v1.35.2
@@ -14,16 +19,13 @@ require (
 require (
 	github.com/andeya/ameda v1.5.3 // indirect
 	github.com/andeya/goutil v1.0.1 // indirect
-	github.com/cloudfly/timex v0.4.8 // indirect
 	github.com/golang/protobuf v1.5.4 // indirect
 	github.com/mattn/go-colorable v0.1.13 // indirect
 	github.com/mattn/go-isatty v0.0.19 // indirect
 	github.com/nyaruka/phonenumbers v1.0.55 // indirect
 	github.com/tidwall/match v1.1.1 // indirect
 	github.com/tidwall/pretty v1.2.0 // indirect
-	golang.org/x/net v0.30.0 // indirect
 	golang.org/x/sys v0.26.0 // indirect
-	golang.org/x/text v0.20.0 // indirect
 	google.golang.org/genproto/googleapis/api v0.0.0-20241118233622-e639e219e697 // indirect
 	google.golang.org/genproto/googleapis/rpc v0.0.0-20241118233622-e639e219e697 // indirect
 )
@@ -4,9 +4,15 @@ import (
 	"context"
 	"encoding/json"
 	"net/http"
+	"strconv"
+	"strings"
 
 	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
 	"github.com/rs/zerolog/log"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/protobuf/proto"
 )
 
@@ -17,10 +23,11 @@ type statusHijack struct {
 	targetCode int
 	hijacked   bool
 	served     bool
+	disabled   bool
 }
 
 func (h *statusHijack) WriteHeader(code int) {
-	if code == h.targetCode {
+	if code == h.targetCode && !h.disabled {
 		// hijack it
 		h.hijacked = true
 		return
@@ -28,6 +35,23 @@ func (h *statusHijack) WriteHeader(code int) {
 	h.ResponseWriter.WriteHeader(code)
 }
 
+// disableHijack disables the status hijacking of the response writer, the response is the error responded by
+// handler(e.g: a 404 error of RawEnvelope) but not the route missing
+func disableHijack(w http.ResponseWriter) {
+	for w != nil {
+		if h, ok := w.(*statusHijack); ok {
+			h.disabled = true
+			w = h.ResponseWriter
+			continue
+		}
+		u, ok := w.(interface{ Unwrap() http.ResponseWriter })
+		if !ok {
+			return
+		}
+		w = u.Unwrap()
+	}
+}
+
 func (h *statusHijack) Write(body []byte) (int, error) {
 	if h.hijacked {
 		if !h.served {
@@ -36,45 +60,86 @@ func (h *statusHijack) Write(body []byte) (int, error) {
 		}
 		return len(body), nil
 	}
-	n, err := h.ResponseWriter.Write(body)
+	return h.ResponseWriter.Write(body)
+}
+
+// Flush implements the http.Flusher interface for the streaming handlers, the response is not flushed on every write,
+// so that the compression MinSize works on the buffered response
+func (h *statusHijack) Flush() {
+	if h.hijacked {
+		return
+	}
 	if flusher, ok := h.ResponseWriter.(http.Flusher); ok {
 		flusher.Flush()
 	}
-	return n, err
+}
+
+// Unwrap return the original http.ResponseWriter, it's used by http.ResponseController
+func (h *statusHijack) Unwrap() http.ResponseWriter {
+	return h.ResponseWriter
 }
 
 type grpcHandler struct {
-	srv *Service
-	mux *runtime.ServeMux
+	srv    *Service
+	mux    *runtime.ServeMux
+	parser *queryParser
 }
 
 func newGRPCHandler(srv *Service) *grpcHandler {
-	gh := &grpcHandler{
-		mux: runtime.NewServeMux(
-			runtime.WithMarshalerOption(
-				runtime.MIMEWildcard, &runtime.JSONBuiltin{},
-			),
-			runtime.SetQueryParameterParser(&queryParser{}),
-			runtime.WithIncomingHeaderMatcher(grpcHeaderMatcher(srv.grpcHeaderPatterns)),
-			runtime.WithErrorHandler(func(ctx context.Context, mux *runtime.ServeMux, _ runtime.Marshaler, w http.ResponseWriter, r *http.Request, err error) {
-				data := ResponseBody{
-					Code:    1,
-					Message: err.Error(),
-				}
-				log.Ctx(ctx).Error().Err(err).Str("method", r.Method).Str("path", r.RequestURI).Msg("Handling rpc request error")
-				content, _ := json.Marshal(data)
-				w.WriteHeader(200)
-				w.Write(content)
-			}),
-			runtime.WithForwardResponseOption(func(ctx context.Context, w http.ResponseWriter, msg proto.Message) error {
-				w.WriteHeader(200)
-				w.Write([]byte(rpcResponseWrapPrefix))
-				return nil
-			}),
+	parser := &queryParser{}
+	if srv.pagination.Gateway {
+		parser.pagination = &srv.pagination
+	}
+	opts := []runtime.ServeMuxOption{
+		runtime.WithMarshalerOption(
+			runtime.MIMEWildcard, &runtime.JSONBuiltin{},
 		),
+		runtime.SetQueryParameterParser(parser),
+		runtime.WithIncomingHeaderMatcher(grpcHeaderMatcher(srv.grpcHeaderPatterns)),
+		runtime.WithErrorHandler(func(ctx context.Context, mux *runtime.ServeMux, _ runtime.Marshaler, w http.ResponseWriter, r *http.Request, err error) {
+			data := ResponseBody{
+				Code:    1,
+				Message: err.Error(),
+			}
+			if qerr, ok := parser.takeError(r.Form); ok {
+				if msg := srv.localizeQueryParamError(r, qerr); msg != qerr.Error() {
+					data.Message = msg
+				}
+			}
+			if fwd := srv.metadataForwarding; fwd != nil {
+				fwd.forwardMetadata(ctx, w, true, fwd.TrailersAsHeaders)
+			}
+			log.Ctx(ctx).Error().Err(err).Str("method", r.Method).Str("path", r.RequestURI).Msg("Handling rpc request error")
+			markFailed(w)
+			code, body := srv.envelope.Error(r, 200, data, err)
+			if _, ok := runtime.HTTPPattern(ctx); ok {
+				disableHijack(w)
+			}
+			content, _ := json.Marshal(body)
+			w.WriteHeader(code)
+			w.Write(content)
+		}),
+		runtime.WithForwardResponseOption(func(ctx context.Context, w http.ResponseWriter, msg proto.Message) error {
+			// the Trailer header is declared by gateway only if the client accepts trailers, otherwise forward them as headers
+			if fwd := srv.metadataForwarding; fwd != nil && fwd.TrailersAsHeaders && len(w.Header().Values("Trailer")) == 0 {
+				fwd.forwardMetadata(ctx, w, false, true)
+			}
+			return nil
+		}),
+		runtime.WithForwardResponseRewriter(srv.gatewayResponseRewriter),
+		runtime.WithMiddlewares(srv.gatewayMiddleware),
+	}
+	if fwd := srv.metadataForwarding; fwd != nil {
+		opts = append(opts,
+			runtime.WithOutgoingHeaderMatcher(fwd.headerMatcher(fwd.Headers)),
+			runtime.WithOutgoingTrailerMatcher(fwd.headerMatcher(fwd.Trailers)),
+		)
+	}
+	return &grpcHandler{
+		srv:    srv,
+		mux:    runtime.NewServeMux(append(opts, srv.gatewayOptions...)...),
+		parser: parser,
 	}
-	gh.srv = srv
-	return gh
 }
 
 func (gh *grpcHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
@@ -86,8 +151,30 @@ func (gh *grpcHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 			handler:        gh.srv.notFoundHandler,
 		}
 	}
-	gh.mux.ServeHTTP(w, r)
-	w.Write([]byte(rpcResponseWrapSuffix))
+	defer func() {
+		if p := recover(); p != nil {
+			if p == http.ErrAbortHandler {
+				panic(p)
+			}
+			gh.srv.failPanic(w, r, gh.srv.handlePanic(r, p))
+		}
+	}()
+	gh.mux.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), gatewayRequestKey, r)))
+}
+
+// gatewayMiddleware applies the service middlewares on the grpc-gateway routes, with the route available by RouteFrom
+func (srv *Service) gatewayMiddleware(next runtime.HandlerFunc) runtime.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request, pathParams map[string]string) {
+		route := &Route{Method: r.Method, Gateway: true}
+		if pattern, ok := runtime.HTTPPattern(r.Context()); ok {
+			route.Pattern = pattern.String()
+		}
+		withRoute(func(w http.ResponseWriter, r *http.Request) {
+			next(w, r, pathParams)
+			// forget the query parameter error if it's not taken by the error handler
+			srv.grpc.parser.takeError(r.Form)
+		}, route, srv.middlewares)(w, r)
+	}
 }
 
 func grpcHeaderMatcher(patterns []string) runtime.HeaderMatcherFunc {
@@ -126,3 +213,85 @@ func matchStr(pattern, s string) bool {
 	}
 	return true
 }
+
+// WithGRPCServer hosts the native gRPC server on the same listener of http service, the HTTP/2 requests with
+// "application/grpc" content type are served by gs, both over TLS and cleartext HTTP/2(h2c).
+// The gRPC requests are not wrapped by the service middlewares, use the gRPC interceptors instead.
+func WithGRPCServer(gs *grpc.Server) ServiceOption {
+	return func(srv *Service) {
+		srv.grpcServer = gs
+	}
+}
+
+// GRPCServer return the native gRPC server specified by WithGRPCServer
+func (srv *Service) GRPCServer() *grpc.Server {
+	return srv.grpcServer
+}
+
+// serveGRPC dispatch the gRPC requests to the grpc server, and others to next, the h2c connections are accepted
+func (srv *Service) serveGRPC(next http.HandlerFunc) http.HandlerFunc {
+	srv.h2 = &http2.Server{}
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.ProtoMajor == 2 && strings.HasPrefix(r.Header.Get("Content-Type"), "application/grpc") {
+			if !srv.acquireGRPC() {
+				// the trailers-only response of gRPC
+				w.Header().Set("Content-Type", "application/grpc")
+				w.Header().Set("Grpc-Status", strconv.Itoa(int(codes.Unavailable)))
+				w.Header().Set("Grpc-Message", "server is shutting down")
+				w.WriteHeader(http.StatusOK)
+				return
+			}
+			defer srv.grpcCalls.Done()
+			srv.grpcServer.ServeHTTP(w, r)
+			return
+		}
+		next(w, r)
+	})
+	return h2c.NewHandler(h, srv.h2).ServeHTTP
+}
+
+// acquireGRPC report whether the gRPC request can be served, it's counted in the in-flight calls if true
+func (srv *Service) acquireGRPC() bool {
+	srv.grpcMu.Lock()
+	defer srv.grpcMu.Unlock()
+	if srv.grpcStopping {
+		return false
+	}
+	srv.grpcCalls.Add(1)
+	return true
+}
+
+// stopGRPC gracefully stops the gRPC server specified by WithGRPCServer, it's stopped forcibly if ctx is done before that.
+func (srv *Service) stopGRPC(ctx context.Context) error {
+	if srv.grpcServer == nil {
+		return nil
+	}
+	srv.grpcMu.Lock()
+	srv.grpcStopping = true
+	srv.grpcMu.Unlock()
+	done := make(chan struct{})
+	go func() {
+		// GracefulStop can't drain the calls served by ServeHTTP(it panics), wait for them to finish at first
+		srv.grpcCalls.Wait()
+		srv.grpcServer.GracefulStop()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		srv.grpcServer.Stop()
+		return ctx.Err()
+	}
+}
+
+// Register registers the service implementation both on the gRPC server(if WithGRPCServer specified) and grpc-gateway mux,
+// by the register functions generated by protoc-gen-go-grpc and protoc-gen-grpc-gateway, e.g:
+//
+//	apix.Register(srv, &greeter{}, pb.RegisterGreeterServer, pb.RegisterGreeterHandlerServer)
+func Register[T any](srv *Service, impl T, registerGRPC func(grpc.ServiceRegistrar, T), registerGateway func(context.Context, *runtime.ServeMux, T) error) error {
+	if srv.grpcServer != nil {
+		registerGRPC(srv.grpcServer, impl)
+	}
+	return registerGateway(context.Background(), srv.GRPCGatewayMux(), impl)
+}
@@ -0,0 +1,60 @@
+package apix
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"google.golang.org/grpc"
+)
+
+func TestStopGRPC(t *testing.T) {
+	gs := grpc.NewServer()
+	srv := New(WithGRPCServer(gs))
+	if err := srv.stopGRPC(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	r := httptest.NewRequest("POST", "/helloworld.Greeter/SayHello", nil)
+	r.ProtoMajor, r.ProtoMinor = 2, 0
+	r.Header.Set("Content-Type", "application/grpc")
+	w := httptest.NewRecorder()
+	srv.ServeHTTP(w, r)
+	if got := w.Header().Get("Grpc-Status"); got != "14" {
+		t.Errorf("got Grpc-Status %q after stopped, want 14", got)
+	}
+}
+
+func TestGatewayPanic(t *testing.T) {
+	tests := []struct {
+		env    Envelope
+		status int
+		want   string
+	}{
+		{DefaultEnvelope, http.StatusInternalServerError, `{"code":500,"message":"internal server error"}`},
+		{RawEnvelope, http.StatusInternalServerError, `{"code":500,"message":"internal server error"}`},
+		{tracedEnvelope{}, http.StatusServiceUnavailable, `{"code":500,"message":"internal server error","trace":"t1"}`},
+	}
+	for _, tt := range tests {
+		srv := New(WithDefaultEnvelope(tt.env))
+		srv.GRPCGatewayMux().HandlePath("GET", "/boom", func(w http.ResponseWriter, r *http.Request, _ map[string]string) {
+			panic("boom")
+		})
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, httptest.NewRequest("GET", "/boom", nil))
+		if w.Code != tt.status {
+			t.Errorf("got status %d, want %d", w.Code, tt.status)
+		}
+		assertJSONEqual(t, json.RawMessage(w.Body.Bytes()), tt.want)
+	}
+}
+
+type tracedEnvelope struct{}
+
+func (tracedEnvelope) Success(_ *http.Request, data any) any { return data }
+
+func (tracedEnvelope) Error(_ *http.Request, _ int, body ResponseBody, _ error) (int, any) {
+	return http.StatusServiceUnavailable, map[string]any{"code": body.Code, "message": body.Message, "trace": "t1"}
+}
@@ -0,0 +1,272 @@
+package apix
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	defaultHealthCheckTimeout = 3 * time.Second
+	healthWatchInterval       = 5 * time.Second
+
+	HealthStatusOK       = "ok"
+	HealthStatusDegraded = "degraded" // some non-critical checks failed
+	HealthStatusFailing  = "failing"
+)
+
+var errShuttingDown = errors.New("service is shutting down")
+
+// HealthCheck is a named check of the service dependency, e.g: database, cache or downstream service
+type HealthCheck struct {
+	Name string
+	// Check returns nil if the dependency is healthy
+	Check func(ctx context.Context) error
+	// Timeout limits the duration of Check, default 3s
+	Timeout time.Duration
+	// Critical fails the probes if the check failed, otherwise the service is only reported as degraded
+	Critical bool
+	// Liveness runs the check in /livez too, only the checks indicating the process should be restarted(e.g: deadlock) should set it
+	Liveness bool
+}
+
+// HealthResult is the result of a health check
+type HealthResult struct {
+	Status   string `json:"status"`
+	Error    string `json:"error,omitempty"`
+	Critical bool   `json:"critical"`
+	Duration string `json:"duration,omitempty"` // e.g: "1.2ms"
+}
+
+// HealthReport is the response body of health endpoints
+type HealthReport struct {
+	Status string                  `json:"status"`
+	Checks map[string]HealthResult `json:"checks,omitempty"`
+}
+
+type healthRegistry struct {
+	mu       sync.RWMutex
+	checks   []HealthCheck
+	draining atomic.Bool
+}
+
+// WithHealthChecks registers the checks and serves the probes endpoints, all of them response the HealthReport in JSON,
+// with 503 status if any critical check failed:
+//
+//   - /livez runs the Liveness checks only
+//   - /readyz runs all the checks, and fails once the service is shutting down
+//   - /healthz runs all the checks
+//
+// The endpoints are not wrapped by the service middlewares and the policies of WithPolicy, so that the probes are not
+// blocked by authentication, authorization or rate limiting.
+func WithHealthChecks(checks ...HealthCheck) ServiceOption {
+	return func(srv *Service) {
+		srv.healthEndpoints = true
+		for _, check := range checks {
+			srv.AddHealthCheck(check)
+		}
+	}
+}
+
+// WithShutdownDelay specifics the delay between flipping readiness to failing and shutting down the server in Shutdown,
+// it should be longer than the period of readiness probes.
+func WithShutdownDelay(d time.Duration) ServiceOption {
+	return func(srv *Service) {
+		srv.shutdownDelay = d
+	}
+}
+
+// AddHealthCheck registers a health check, the check with the same name is replaced
+func (srv *Service) AddHealthCheck(check HealthCheck) {
+	if check.Name == "" || check.Check == nil {
+		panic("health check name and function required")
+	}
+	if check.Timeout <= 0 {
+		check.Timeout = defaultHealthCheckTimeout
+	}
+	h := srv.health
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for i, c := range h.checks {
+		if c.Name == check.Name {
+			h.checks[i] = check
+			return
+		}
+	}
+	h.checks = append(h.checks, check)
+}
+
+// CheckHealth runs the checks concurrently and returns the report, only the Liveness checks are run if liveness is true
+func (srv *Service) CheckHealth(ctx context.Context, liveness bool) HealthReport {
+	h := srv.health
+	h.mu.RLock()
+	checks := make([]HealthCheck, 0, len(h.checks))
+	for _, c := range h.checks {
+		if !liveness || c.Liveness {
+			checks = append(checks, c)
+		}
+	}
+	h.mu.RUnlock()
+
+	results := make([]HealthResult, len(checks))
+	var wg sync.WaitGroup
+	for i, c := range checks {
+		wg.Add(1)
+		go func(i int, c HealthCheck) {
+			defer wg.Done()
+			results[i] = runHealthCheck(ctx, c)
+		}(i, c)
+	}
+	wg.Wait()
+
+	report := HealthReport{Status: HealthStatusOK, Checks: make(map[string]HealthResult, len(checks))}
+	for i, c := range checks {
+		report.Checks[c.Name] = results[i]
+		if results[i].Status == HealthStatusOK {
+			continue
+		}
+		if c.Critical {
+			report.Status = HealthStatusFailing
+		} else if report.Status == HealthStatusOK {
+			report.Status = HealthStatusDegraded
+		}
+	}
+	return report
+}
+
+// checkReadiness returns the report of all checks, it's failing once the service is shutting down
+func (srv *Service) checkReadiness(ctx context.Context) HealthReport {
+	if srv.health.draining.Load() {
+		return HealthReport{Status: HealthStatusFailing, Checks: map[string]HealthResult{
+			"shutdown": {Status: HealthStatusFailing, Error: errShuttingDown.Error(), Critical: true},
+		}}
+	}
+	return srv.CheckHealth(ctx, false)
+}
+
+func runHealthCheck(ctx context.Context, c HealthCheck) (result HealthResult) {
+	ctx, cancel := context.WithTimeout(ctx, c.Timeout)
+	defer cancel()
+	start := time.Now()
+	result = HealthResult{Status: HealthStatusOK, Critical: c.Critical}
+	defer func() {
+		result.Duration = time.Since(start).String()
+	}()
+
+	done := make(chan error, 1)
+	go func() {
+		// the check runs in its own goroutine, so the panic must be recovered here
+		defer func() {
+			if v := recover(); v != nil {
+				done <- fmt.Errorf("panic: %v", v)
+			}
+		}()
+		done <- c.Check(ctx)
+	}()
+	var err error
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	if err != nil {
+		result.Status, result.Error = HealthStatusFailing, err.Error()
+	}
+	return result
+}
+
+// registerHealthEndpoints registers the probes endpoints without the service middlewares
+func (srv *Service) registerHealthEndpoints() {
+	probe := func(check func(ctx context.Context) HealthReport) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			report := check(r.Context())
+			status := http.StatusOK
+			if report.Status == HealthStatusFailing {
+				status = http.StatusServiceUnavailable
+			}
+			w.Header().Set("Cache-Control", "no-store")
+			ReturnJSON(w, status, report)
+		}
+	}
+	opts := []RouteOption{func(r *Route) { r.internal = true }}
+	srv.handle("GET", "/livez", probe(func(ctx context.Context) HealthReport { return srv.CheckHealth(ctx, true) }), nil, opts)
+	srv.handle("GET", "/readyz", probe(srv.checkReadiness), nil, opts)
+	srv.handle("GET", "/healthz", probe(func(ctx context.Context) HealthReport { return srv.CheckHealth(ctx, false) }), nil, opts)
+}
+
+// HealthServer returns the grpc.health.v1 server backed by the health checks, register it on the grpc server so that the
+// gRPC clients and probes get the same health as the http endpoints:
+//
+//	healthpb.RegisterHealthServer(grpcServer, srv.HealthServer())
+//
+// The empty service name reports the readiness of service, and the other names report the check with the same name.
+func (srv *Service) HealthServer() healthpb.HealthServer {
+	return &healthServer{srv: srv}
+}
+
+type healthServer struct {
+	healthpb.UnimplementedHealthServer
+	srv *Service
+}
+
+func (hs *healthServer) Check(ctx context.Context, req *healthpb.HealthCheckRequest) (*healthpb.HealthCheckResponse, error) {
+	st, err := hs.status(ctx, req.GetService())
+	if err != nil {
+		return nil, err
+	}
+	return &healthpb.HealthCheckResponse{Status: st}, nil
+}
+
+// Watch polls the health status periodically and sends it on changes
+func (hs *healthServer) Watch(req *healthpb.HealthCheckRequest, stream healthpb.Health_WatchServer) error {
+	ctx := stream.Context()
+	ticker := time.NewTicker(healthWatchInterval)
+	defer ticker.Stop()
+	last := healthpb.HealthCheckResponse_UNKNOWN
+	for first := true; ; first = false {
+		st, err := hs.status(ctx, req.GetService())
+		if err != nil {
+			st = healthpb.HealthCheckResponse_SERVICE_UNKNOWN
+		}
+		if first || st != last {
+			if err := stream.Send(&healthpb.HealthCheckResponse{Status: st}); err != nil {
+				return err
+			}
+			last = st
+		}
+		select {
+		case <-ctx.Done():
+			return status.FromContextError(ctx.Err()).Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+func (hs *healthServer) status(ctx context.Context, service string) (healthpb.HealthCheckResponse_ServingStatus, error) {
+	report := hs.srv.checkReadiness(ctx)
+	if service == "" {
+		if report.Status == HealthStatusFailing {
+			return healthpb.HealthCheckResponse_NOT_SERVING, nil
+		}
+		return healthpb.HealthCheckResponse_SERVING, nil
+	}
+	result, ok := report.Checks[service]
+	if !ok {
+		if hs.srv.health.draining.Load() {
+			return healthpb.HealthCheckResponse_NOT_SERVING, nil
+		}
+		return healthpb.HealthCheckResponse_SERVICE_UNKNOWN, status.Errorf(codes.NotFound, "unknown service %q", service)
+	}
+	if result.Status != HealthStatusOK {
+		return healthpb.HealthCheckResponse_NOT_SERVING, nil
+	}
+	return healthpb.HealthCheckResponse_SERVING, nil
+}
@@ -0,0 +1,250 @@
+package apix
+
+import (
+	"errors"
+	"net/http"
+	"reflect"
+	"strings"
+	"sync"
+
+	"github.com/bytedance/go-tagexpr/v2/binding"
+	"golang.org/x/text/language"
+)
+
+// The rules of validation errors, they're used as the message key in Catalog
+const (
+	RuleRequired    = "required"     // required parameter missing
+	RuleType        = "type"         // parameter type mismatched
+	RuleBind        = "bind"         // parameter can not be bound
+	RuleContentType = "content_type" // request content type not supported
+	RuleInvalid     = "invalid"      // vd expression failed
+)
+
+var (
+	// DefaultCatalog contains the English and Chinese messages of the builtin rules
+	DefaultCatalog = newDefaultCatalog()
+
+	bindingErrorRules = map[string]string{
+		"missing required parameter":                         RuleRequired,
+		"parameter type does not match binding data":         RuleType,
+		"parameter cannot be bound":                          RuleBind,
+		"does not support binding to the content type body":  RuleContentType,
+		"receiver must be a non-nil pointer":                 RuleBind,
+		"the receiver is not a struct pointer or is invalid": RuleBind,
+	}
+)
+
+// Catalog is the localized message catalog of validation errors.
+//
+// The messages are keyed by "<field>.<rule>" or "<rule>", field is the parameter path in error(e.g: "user.name"),
+// and rule is one of the Rule* constants, or the msg in vd tag(e.g: `vd:"len($)>0; msg:'name_empty'"`).
+// The "{field}" and "{cause}" placeholders in message are replaced by the field path and original error message.
+type Catalog struct {
+	mu       sync.RWMutex
+	tags     []language.Tag
+	messages []map[string]string
+	matcher  language.Matcher
+}
+
+func newDefaultCatalog() *Catalog {
+	c := NewCatalog("en")
+	c.SetMessages("en", map[string]string{
+		RuleRequired:    "{field} is required",
+		RuleType:        "{field} has an invalid type",
+		RuleBind:        "{field} cannot be bound",
+		RuleContentType: "the content type is not supported",
+		RuleInvalid:     "{field} is invalid",
+	})
+	c.SetMessages("zh", map[string]string{
+		RuleRequired:    "{field}不能为空",
+		RuleType:        "{field}类型错误",
+		RuleBind:        "{field}无法解析",
+		RuleContentType: "不支持的请求内容类型",
+		RuleInvalid:     "{field}不合法",
+	})
+	return c
+}
+
+// NewCatalog create a empty Catalog, the fallback language is used if none of the Accept-Language matches
+func NewCatalog(fallback string) *Catalog {
+	c := &Catalog{}
+	c.SetMessages(fallback, map[string]string{})
+	return c
+}
+
+// SetMessage set the message of key in language lang
+func (c *Catalog) SetMessage(lang, key, message string) *Catalog {
+	return c.SetMessages(lang, map[string]string{key: message})
+}
+
+// SetMessages set the messages in language lang
+func (c *Catalog) SetMessages(lang string, messages map[string]string) *Catalog {
+	tag := language.Make(lang)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for i, t := range c.tags {
+		if t == tag {
+			for k, v := range messages {
+				c.messages[i][k] = v
+			}
+			return c
+		}
+	}
+	m := make(map[string]string, len(messages))
+	for k, v := range messages {
+		m[k] = v
+	}
+	c.tags = append(c.tags, tag)
+	c.messages = append(c.messages, m)
+	c.matcher = language.NewMatcher(c.tags)
+	return c
+}
+
+// Lookup return the message of key in the language best matching the Accept-Language header, the fallback language is also searched
+func (c *Catalog) Lookup(acceptLanguage, key string) (string, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	_, idx := c.match(acceptLanguage)
+	if msg, ok := c.messages[idx][key]; ok {
+		return msg, true
+	}
+	msg, ok := c.messages[0][key]
+	return msg, ok
+}
+
+// Language return the language best matching the Accept-Language header
+func (c *Catalog) Language(acceptLanguage string) language.Tag {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	tag, _ := c.match(acceptLanguage)
+	return tag
+}
+
+func (c *Catalog) match(acceptLanguage string) (language.Tag, int) {
+	prefs, _, err := language.ParseAcceptLanguage(acceptLanguage)
+	if err != nil || len(prefs) == 0 {
+		return c.tags[0], 0
+	}
+	_, idx, confidence := c.matcher.Match(prefs...)
+	if confidence == language.No {
+		return c.tags[0], 0
+	}
+	return c.tags[idx], idx
+}
+
+// localize translate the message of field and rule into the language in Accept-Language header.
+// The field's struct tag is searched first, e.g: `msg_zh:"名称不能为空" msg:"name is required"`.
+func (c *Catalog) localize(acceptLanguage string, field reflect.StructField, hasField bool, path, rule, cause string) (string, bool) {
+	var msg string
+	if hasField {
+		if name := paramName(field); name != "" {
+			// display the parameter name instead of struct field name
+			if i := strings.LastIndex(path, "."); i >= 0 {
+				path = path[:i+1] + name
+			} else {
+				path = name
+			}
+		}
+		tag := c.Language(acceptLanguage)
+		base, _ := tag.Base()
+		for _, key := range []string{"msg_" + tag.String(), "msg_" + base.String(), "msg"} {
+			if msg = field.Tag.Get(key); msg != "" {
+				break
+			}
+		}
+	}
+	if msg == "" {
+		var ok bool
+		if msg, ok = c.Lookup(acceptLanguage, path+"."+rule); !ok {
+			if msg, ok = c.Lookup(acceptLanguage, rule); !ok {
+				return "", false
+			}
+		}
+	}
+	return strings.NewReplacer("{field}", path, "{cause}", cause).Replace(msg), true
+}
+
+// WithMessageCatalog localizes the validation errors of binding and grpc-gateway query parameters by the catalog,
+// according to the Accept-Language header of request.
+func WithMessageCatalog(c *Catalog) ServiceOption {
+	return func(srv *Service) {
+		srv.catalog = c
+	}
+}
+
+// localizeBindingError translate the error returned by binding, it returns the original error message if it can not be translated
+func (srv *Service) localizeBindingError(r *http.Request, v any, err error) string {
+	var be *binding.Error
+	if srv.catalog == nil || !errors.As(err, &be) {
+		return err.Error()
+	}
+	rule, cause := RuleInvalid, be.Msg
+	if be.ErrType == "binding" {
+		if br, ok := bindingErrorRules[be.Msg]; ok {
+			rule = br
+		}
+	} else if be.Msg != "" {
+		// the msg in vd tag is used as rule, so that it can be translated by catalog
+		rule = be.Msg
+	}
+	field, ok := lookupStructField(reflect.TypeOf(v), be.FailField)
+	msg, ok := srv.catalog.localize(r.Header.Get("Accept-Language"), field, ok, be.FailField, rule, cause)
+	if !ok {
+		if be.ErrType != "binding" && be.Msg != "" {
+			// the message in vd tag is not a key in catalog, use it directly
+			return be.Msg
+		}
+		return err.Error()
+	}
+	return msg
+}
+
+// localizeQueryParamError translate the error of queryParser, the original message is returned if it's not a queryParamError
+func (srv *Service) localizeQueryParamError(r *http.Request, err error) string {
+	var qerr *queryParamError
+	if srv.catalog == nil || !errors.As(err, &qerr) {
+		return err.Error()
+	}
+	if msg, ok := srv.catalog.localize(r.Header.Get("Accept-Language"), reflect.StructField{}, false, qerr.field, qerr.rule, qerr.err.Error()); ok {
+		return msg
+	}
+	return err.Error()
+}
+
+// lookupStructField find the struct field by the path in error, the segments of path may be field name or json name
+func lookupStructField(t reflect.Type, path string) (reflect.StructField, bool) {
+	var (
+		field reflect.StructField
+		found bool
+	)
+	for _, name := range strings.Split(path, ".") {
+		for t != nil && (t.Kind() == reflect.Pointer || t.Kind() == reflect.Slice || t.Kind() == reflect.Array || t.Kind() == reflect.Map) {
+			t = t.Elem()
+		}
+		if t == nil || t.Kind() != reflect.Struct {
+			return field, false
+		}
+		found = false
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			if f.Name == name || paramName(f) == name {
+				field, found, t = f, true, f.Type
+				break
+			}
+		}
+		if !found {
+			return field, false
+		}
+	}
+	return field, found
+}
+
+// paramName return the name of field in json, query, form, path or header tag
+func paramName(f reflect.StructField) string {
+	for _, key := range []string{"json", "query", "form", "path", "header"} {
+		if name, _, _ := strings.Cut(f.Tag.Get(key), ","); name != "" && name != "-" {
+			return name
+		}
+	}
+	return ""
+}
@@ -0,0 +1,264 @@
+package apix
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	defaultIdempotencyHeader = "Idempotency-Key"
+	defaultIdempotencyTTL    = 24 * time.Hour
+	defaultIdempotencyLock   = time.Minute
+	defaultIdempotencyBody   = 10 << 20
+)
+
+// IdempotencyConfig configures the Idempotency middleware
+type IdempotencyConfig struct {
+	// Header is the request header carrying the idempotency key, default "Idempotency-Key"
+	Header string
+	// TTL is the duration the first response is stored for replaying, default 24h
+	TTL time.Duration
+	// LockTimeout is the max duration the key is locked by a processing request, default 1m
+	LockTimeout time.Duration
+	// Required rejects the requests without idempotency key with 400 status
+	Required bool
+	// MaxBodySize is the max size of request body read for fingerprinting, the larger requests are rejected by 413 status,
+	// default 10MB
+	MaxBodySize int64
+	// Store stores the responses, an in-memory store is used if nil
+	Store IdempotencyStore
+}
+
+// IdempotentResponse is the response stored in IdempotencyStore
+type IdempotentResponse struct {
+	// Fingerprint is the hash of the request body, a key can not be reused with a different body
+	Fingerprint string
+	Status      int
+	Header      http.Header
+	Body        []byte
+}
+
+// IdempotencyStore stores the responses of idempotent requests, implement it on a shared store(eg. redis) so that it works across instances.
+type IdempotencyStore interface {
+	// Lock acquires the key for processing until Unlock called or ttl expired, it returns false if the key is locked by others.
+	// The returned token identifies the lock owner.
+	Lock(ctx context.Context, key string, ttl time.Duration) (token string, locked bool, err error)
+	// Unlock release the key if it's still locked by the token, the lock acquired by others after expiring is kept
+	Unlock(ctx context.Context, key, token string) error
+	// Get return the stored response of key, it returns nil without error if not found
+	Get(ctx context.Context, key string) (*IdempotentResponse, error)
+	// Set stores the response of key, it should be expired after ttl
+	Set(ctx context.Context, key string, resp *IdempotentResponse, ttl time.Duration) error
+}
+
+// Idempotency create a middleware making the POST and PATCH requests idempotent by the Idempotency-Key header.
+// The first response of a key is stored and replayed for the retries, except the errors responded by handlers(whatever the
+// envelope renders them), 5xx and 429 responses, so that the client can retry them. The concurrent requests with the same key
+// are rejected by 409 status, and reusing a key with different request body is rejected by 422 status.
+// The keys are isolated by request principal, method and path.
+func Idempotency(cfg IdempotencyConfig) Middleware {
+	if cfg.Header == "" {
+		cfg.Header = defaultIdempotencyHeader
+	}
+	if cfg.TTL <= 0 {
+		cfg.TTL = defaultIdempotencyTTL
+	}
+	if cfg.LockTimeout <= 0 {
+		cfg.LockTimeout = defaultIdempotencyLock
+	}
+	if cfg.MaxBodySize <= 0 {
+		cfg.MaxBodySize = defaultIdempotencyBody
+	}
+	if cfg.Store == nil {
+		cfg.Store = NewMemoryIdempotencyStore()
+	}
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != http.MethodPost && r.Method != http.MethodPatch {
+				next(w, r)
+				return
+			}
+			idemKey := r.Header.Get(cfg.Header)
+			if idemKey == "" {
+				if cfg.Required {
+					ReturnJSON(w, http.StatusBadRequest, ResponseBody{Code: http.StatusBadRequest, Message: cfg.Header + " header required"})
+					return
+				}
+				next(w, r)
+				return
+			}
+
+			body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, cfg.MaxBodySize))
+			if err != nil {
+				var maxErr *http.MaxBytesError
+				if errors.As(err, &maxErr) {
+					ReturnJSON(w, http.StatusRequestEntityTooLarge, ResponseBody{Code: http.StatusRequestEntityTooLarge, Message: err.Error()})
+					return
+				}
+				ReturnJSON(w, http.StatusBadRequest, ResponseBody{Code: http.StatusBadRequest, Message: err.Error()})
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewReader(body))
+			sum := sha256.Sum256(body)
+			fingerprint := hex.EncodeToString(sum[:])
+			key := idempotencyKey(r, idemKey)
+
+			ctx := r.Context()
+			resp, err := cfg.Store.Get(ctx, key)
+			if err != nil {
+				log.Ctx(ctx).Error().Err(err).Str("key", idemKey).Msg("Getting idempotent response error")
+				ReturnJSON(w, http.StatusServiceUnavailable, ResponseBody{Code: http.StatusServiceUnavailable, Message: err.Error()})
+				return
+			}
+			if resp != nil {
+				replayIdempotentResponse(w, resp, fingerprint)
+				return
+			}
+
+			token, locked, err := cfg.Store.Lock(ctx, key, cfg.LockTimeout)
+			if err != nil {
+				log.Ctx(ctx).Error().Err(err).Str("key", idemKey).Msg("Locking idempotency key error")
+				ReturnJSON(w, http.StatusServiceUnavailable, ResponseBody{Code: http.StatusServiceUnavailable, Message: err.Error()})
+				return
+			}
+			if !locked {
+				ReturnJSON(w, http.StatusConflict, ResponseBody{Code: http.StatusConflict, Message: "a request with the same " + cfg.Header + " is being processed"})
+				return
+			}
+			defer cfg.Store.Unlock(context.WithoutCancel(ctx), key, token)
+
+			// the response may be stored by the request just finished before locking
+			if resp, err = cfg.Store.Get(ctx, key); err == nil && resp != nil {
+				replayIdempotentResponse(w, resp, fingerprint)
+				return
+			}
+
+			rec := &cacheRecorder{ResponseWriter: w}
+			next(rec, r)
+			if rec.status == 0 || rec.status >= 500 || rec.status == http.StatusTooManyRequests || rec.failed {
+				// let the client retry on server errors, rate limiting and the errors responded by handler
+				return
+			}
+			resp = &IdempotentResponse{
+				Fingerprint: fingerprint,
+				Status:      rec.status,
+				Header:      cloneRecordedHeader(w.Header()),
+				Body:        rec.body,
+			}
+			if err := cfg.Store.Set(context.WithoutCancel(ctx), key, resp, cfg.TTL); err != nil {
+				log.Ctx(ctx).Error().Err(err).Str("key", idemKey).Msg("Storing idempotent response error")
+			}
+		}
+	}
+}
+
+func replayIdempotentResponse(w http.ResponseWriter, resp *IdempotentResponse, fingerprint string) {
+	if resp.Fingerprint != fingerprint {
+		ReturnJSON(w, http.StatusUnprocessableEntity, ResponseBody{Code: http.StatusUnprocessableEntity, Message: "idempotency key is reused with a different request"})
+		return
+	}
+	header := w.Header()
+	for k, v := range resp.Header {
+		header[k] = append([]string{}, v...)
+	}
+	header.Set("Idempotent-Replayed", "true")
+	w.WriteHeader(resp.Status)
+	w.Write(resp.Body)
+}
+
+func idempotencyKey(r *http.Request, key string) string {
+	var principal string
+	if p := PrincipalFrom(r.Context()); p != nil {
+		principal = p.ID
+	}
+	sum := sha256.Sum256([]byte(principal + "\n" + r.Method + " " + r.URL.Path + "\n" + key))
+	return hex.EncodeToString(sum[:])
+}
+
+type memoryIdempotencyEntry struct {
+	resp   *IdempotentResponse
+	expire time.Time
+}
+
+type memoryIdempotencyLock struct {
+	token  string
+	expire time.Time
+}
+
+// memoryIdempotencyStore is an in-memory IdempotencyStore
+type memoryIdempotencyStore struct {
+	mu        sync.Mutex
+	entries   map[string]memoryIdempotencyEntry
+	locks     map[string]memoryIdempotencyLock
+	lockSeq   uint64
+	lastSweep time.Time
+}
+
+// NewMemoryIdempotencyStore create an in-memory IdempotencyStore, it only works in current process
+func NewMemoryIdempotencyStore() IdempotencyStore {
+	return &memoryIdempotencyStore{
+		entries:   make(map[string]memoryIdempotencyEntry),
+		locks:     make(map[string]memoryIdempotencyLock),
+		lastSweep: time.Now(),
+	}
+}
+
+func (s *memoryIdempotencyStore) Lock(_ context.Context, key string, ttl time.Duration) (string, bool, error) {
+	now := time.Now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if lock, ok := s.locks[key]; ok && now.Before(lock.expire) {
+		return "", false, nil
+	}
+	s.lockSeq++
+	token := strconv.FormatUint(s.lockSeq, 10)
+	s.locks[key] = memoryIdempotencyLock{token: token, expire: now.Add(ttl)}
+	return token, true, nil
+}
+
+func (s *memoryIdempotencyStore) Unlock(_ context.Context, key, token string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if lock, ok := s.locks[key]; ok && lock.token == token {
+		delete(s.locks, key)
+	}
+	return nil
+}
+
+func (s *memoryIdempotencyStore) Get(_ context.Context, key string) (*IdempotentResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entry, ok := s.entries[key]
+	if !ok || time.Now().After(entry.expire) {
+		return nil, nil
+	}
+	return entry.resp, nil
+}
+
+func (s *memoryIdempotencyStore) Set(_ context.Context, key string, resp *IdempotentResponse, ttl time.Duration) error {
+	now := time.Now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// remove the expired entries periodically
+	if now.Sub(s.lastSweep) > time.Minute {
+		for k, e := range s.entries {
+			if now.After(e.expire) {
+				delete(s.entries, k)
+			}
+		}
+		s.lastSweep = now
+	}
+	s.entries[key] = memoryIdempotencyEntry{resp: resp, expire: now.Add(ttl)}
+	return nil
+}
@@ -0,0 +1,63 @@
+package apix
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+var idempotentCalls int
+
+type idempotentCharge struct {
+	Fail bool `query:"fail"`
+}
+
+func (h *idempotentCharge) Execute(ctx *Context) (any, error) {
+	idempotentCalls++
+	if h.Fail {
+		return nil, errors.New("card declined")
+	}
+	// the resource has its own code field
+	return map[string]any{"code": 7, "amount": 100}, nil
+}
+
+func TestIdempotency(t *testing.T) {
+	tests := []struct {
+		name  string
+		env   Envelope
+		fail  bool
+		calls int
+	}{
+		{"default envelope", DefaultEnvelope, false, 1},
+		{"raw envelope", RawEnvelope, false, 1},
+		{"default envelope error", DefaultEnvelope, true, 2},
+		{"raw envelope error", RawEnvelope, true, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			idempotentCalls = 0
+			srv := New(WithMiddleware(Idempotency(IdempotencyConfig{})))
+			srv.POST("/charges", &idempotentCharge{}, WithEnvelope(tt.env))
+			target := "/charges"
+			if tt.fail {
+				target += "?fail=true"
+			}
+			var replayed []string
+			for i := 0; i < 2; i++ {
+				r := httptest.NewRequest(http.MethodPost, target, strings.NewReader(`{"amount":100}`))
+				r.Header.Set("Idempotency-Key", "k1")
+				w := httptest.NewRecorder()
+				srv.ServeHTTP(w, r)
+				replayed = append(replayed, w.Header().Get("Idempotent-Replayed"))
+			}
+			if idempotentCalls != tt.calls {
+				t.Errorf("got %d calls, want %d", idempotentCalls, tt.calls)
+			}
+			if want := tt.calls == 1; (replayed[1] == "true") != want {
+				t.Errorf("got replayed %q, want %v", replayed[1], want)
+			}
+		})
+	}
+}
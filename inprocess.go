@@ -0,0 +1,71 @@
+package apix
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+const inProcessBufferSize = 1 << 20
+
+// WithInProcessGRPCServer specifics the gRPC server only serving the grpc-gateway in process by RegisterInProcess,
+// it's not exposed on the http listener unlike WithGRPCServer.
+func WithInProcessGRPCServer(gs *grpc.Server) ServiceOption {
+	return func(srv *Service) {
+		srv.grpcServer = gs
+		srv.grpcInProcessOnly = true
+	}
+}
+
+// RegisterInProcess registers the service implementation on the gRPC server, and wires the grpc-gateway to it through
+// an in-memory connection, by the register functions generated by protoc-gen-go-grpc and protoc-gen-grpc-gateway, e.g:
+//
+//	apix.RegisterInProcess(srv, &greeter{}, pb.RegisterGreeterServer, pb.RegisterGreeterHandler)
+//
+// Unlike Register, the gateway requests go through the gRPC interceptors, and server streaming and metadata work exactly
+// as over the network, without opening a port. The gRPC server is specified by WithGRPCServer or WithInProcessGRPCServer,
+// the caller should stop it on shutdown.
+func RegisterInProcess[T any](srv *Service, impl T, registerGRPC func(grpc.ServiceRegistrar, T), registerGateway func(context.Context, *runtime.ServeMux, *grpc.ClientConn) error) error {
+	if srv.grpcServer == nil {
+		return errors.New("gRPC server not specified, use WithGRPCServer or WithInProcessGRPCServer")
+	}
+	registerGRPC(srv.grpcServer, impl)
+	conn, err := srv.InProcessConn()
+	if err != nil {
+		return err
+	}
+	return registerGateway(context.Background(), srv.GRPCGatewayMux(), conn)
+}
+
+// InProcessConn return the client connection to the gRPC server through an in-memory listener, the gRPC server starts
+// serving the listener on the first dialing(the first gateway request), so the services can be registered until then.
+func (srv *Service) InProcessConn() (*grpc.ClientConn, error) {
+	srv.inProcessOnce.Do(func() {
+		if srv.grpcServer == nil {
+			srv.inProcessErr = errors.New("gRPC server not specified, use WithGRPCServer or WithInProcessGRPCServer")
+			return
+		}
+		var (
+			lis   = bufconn.Listen(inProcessBufferSize)
+			serve sync.Once
+		)
+		dialer := func(ctx context.Context, _ string) (net.Conn, error) {
+			serve.Do(func() { go srv.grpcServer.Serve(lis) })
+			return lis.DialContext(ctx)
+		}
+		srv.inProcessConn, srv.inProcessErr = grpc.NewClient("passthrough:///apix-in-process",
+			grpc.WithContextDialer(dialer),
+			grpc.WithTransportCredentials(insecure.NewCredentials()),
+		)
+		if srv.inProcessErr != nil {
+			lis.Close()
+		}
+	})
+	return srv.inProcessConn, srv.inProcessErr
+}
@@ -0,0 +1,185 @@
+package apix
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// UnaryServerInterceptor runs the apix middlewares as a gRPC unary interceptor, so that the same auth, metrics and logging
+// work on the native gRPC server. The middlewares get a POST request with the gRPC method as path and the incoming
+// metadata as headers, the headers set by middlewares are sent as the response header metadata.
+// If a middleware responds without calling next, the http status is converted to the gRPC status code.
+func UnaryServerInterceptor(middlewares ...Middleware) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp any, err error) {
+		err = runMiddlewares(ctx, info.FullMethod, middlewares, func(ctx context.Context) error {
+			resp, err = handler(ctx, req)
+			return err
+		})
+		return resp, err
+	}
+}
+
+// StreamServerInterceptor runs the apix middlewares as a gRPC stream interceptor, see UnaryServerInterceptor
+func StreamServerInterceptor(middlewares ...Middleware) grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		return runMiddlewares(ss.Context(), info.FullMethod, middlewares, func(ctx context.Context) error {
+			return handler(srv, &contextServerStream{ServerStream: ss, ctx: ctx})
+		})
+	}
+}
+
+// UnaryInterceptorMiddleware runs the gRPC unary interceptor as an apix middleware, so that the existing interceptors work
+// on the native and grpc-gateway routes. The interceptor gets the request headers as incoming metadata, the *http.Request
+// as the request message, and the route pattern(or RPCMethod) as the FullMethod. The error returned by interceptor before
+// calling the handler is responded with the http status converted from the gRPC status code.
+func UnaryInterceptorMiddleware(interceptor grpc.UnaryServerInterceptor) Middleware {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			info := &grpc.UnaryServerInfo{FullMethod: r.URL.Path}
+			if route := RouteFrom(r.Context()); route != nil {
+				info.FullMethod = route.Pattern
+				if route.RPCMethod != "" {
+					info.FullMethod = route.RPCMethod
+				}
+			}
+			md := metadata.MD{}
+			for k, v := range r.Header {
+				md[strings.ToLower(k)] = v
+			}
+			if incoming, ok := metadata.FromIncomingContext(r.Context()); ok {
+				md = metadata.Join(incoming, md)
+			}
+			called := false
+			_, err := interceptor(metadata.NewIncomingContext(r.Context(), md), r, info, func(ctx context.Context, _ any) (any, error) {
+				called = true
+				next(w, r.WithContext(ctx))
+				return nil, nil
+			})
+			if err != nil && !called {
+				st := status.Convert(err)
+				code := runtime.HTTPStatusFromCode(st.Code())
+				ReturnJSON(w, code, ResponseBody{Code: code, Message: st.Message()})
+			}
+		}
+	}
+}
+
+// runMiddlewares runs the middlewares on a synthetic http request of the gRPC call, and calls handler in the end
+func runMiddlewares(ctx context.Context, fullMethod string, middlewares []Middleware, handler func(ctx context.Context) error) error {
+	r := &http.Request{
+		Method:     http.MethodPost,
+		URL:        &url.URL{Path: fullMethod},
+		RequestURI: fullMethod,
+		Proto:      "HTTP/2.0",
+		ProtoMajor: 2,
+		Header:     http.Header{},
+		Body:       http.NoBody,
+	}
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		for k, v := range md {
+			if !strings.HasPrefix(k, ":") {
+				r.Header[http.CanonicalHeaderKey(k)] = v
+			}
+		}
+		if authority := md.Get(":authority"); len(authority) > 0 {
+			r.Host = authority[0]
+		}
+	}
+	route := &Route{Method: http.MethodPost, Pattern: fullMethod, RPCMethod: fullMethod}
+
+	var (
+		rec    = &cacheRecorder{ResponseWriter: &discardResponseWriter{header: http.Header{}}}
+		called bool
+		err    error
+	)
+	withRoute(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+		if md := headerMetadata(w.Header()); len(md) > 0 {
+			grpc.SetHeader(r.Context(), md)
+		}
+		err = handler(r.Context())
+	}, route, middlewares)(rec, r.WithContext(ctx))
+	if called {
+		return err
+	}
+
+	// the request is intercepted by middleware
+	if md := headerMetadata(rec.Header()); len(md) > 0 {
+		grpc.SetHeader(ctx, md)
+	}
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	message := http.StatusText(rec.status)
+	var body ResponseBody
+	if json.Unmarshal(rec.body, &body) == nil && body.Message != "" {
+		message = body.Message
+	} else if text := strings.TrimSpace(string(rec.body)); text != "" {
+		message = text
+	}
+	return status.Error(grpcCodeFromHTTPStatus(rec.status), message)
+}
+
+// headerMetadata convert the response headers into metadata, the headers of http transport are ignored
+func headerMetadata(header http.Header) metadata.MD {
+	md := metadata.MD{}
+	for k, v := range header {
+		switch k {
+		case "Content-Type", "Content-Length", "Content-Encoding", "Transfer-Encoding", "Connection", "Vary":
+			continue
+		}
+		md[strings.ToLower(k)] = v
+	}
+	return md
+}
+
+// grpcCodeFromHTTPStatus is the reverse of runtime.HTTPStatusFromCode
+func grpcCodeFromHTTPStatus(code int) codes.Code {
+	switch code {
+	case http.StatusBadRequest:
+		return codes.InvalidArgument
+	case http.StatusUnauthorized:
+		return codes.Unauthenticated
+	case http.StatusForbidden:
+		return codes.PermissionDenied
+	case http.StatusNotFound:
+		return codes.NotFound
+	case http.StatusConflict:
+		return codes.Aborted
+	case http.StatusPreconditionFailed:
+		return codes.FailedPrecondition
+	case http.StatusRequestEntityTooLarge, http.StatusTooManyRequests:
+		return codes.ResourceExhausted
+	case 499:
+		return codes.Canceled
+	case http.StatusNotImplemented:
+		return codes.Unimplemented
+	case http.StatusServiceUnavailable:
+		return codes.Unavailable
+	case http.StatusGatewayTimeout:
+		return codes.DeadlineExceeded
+	}
+	if code >= 500 {
+		return codes.Internal
+	}
+	return codes.Unknown
+}
+
+// contextServerStream replace the context of grpc.ServerStream
+type contextServerStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *contextServerStream) Context() context.Context {
+	return s.ctx
+}
@@ -0,0 +1,397 @@
+package apix
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/rs/zerolog/log"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+const (
+	defaultPageSize    = 20
+	defaultMaxPageSize = 100
+)
+
+var (
+	// ErrInvalidCursor is returned if the cursor is tampered, expired by key rotation, or not encoded by the CursorCodec
+	ErrInvalidCursor = errors.New("invalid cursor")
+
+	errPaginationContext = errors.New("pagination requires the Context of service")
+	randomCursorKeyOnce  sync.Once
+)
+
+// PaginationConfig configures the pagination of service
+type PaginationConfig struct {
+	// DefaultSize is the page size if it's not specified by client, default 20
+	DefaultSize int
+	// MaxSize is the max page size, the larger size is coerced to it, default 100
+	MaxSize int
+	// Cursor encodes the opaque cursors, it should be specified with a secret key shared by all the instances.
+	// A codec with random key is used if nil, the cursors are invalid after restarting and across instances then,
+	// and a warning is logged on the first use of it.
+	Cursor *CursorCodec
+	// Gateway enforces the page size limits on the "page_size" field and verifies the "page_token" field by Cursor,
+	// for the gateway list requests following the AIP-158 conventions
+	Gateway bool
+
+	randomKey bool
+}
+
+// WithPagination specifics the pagination config of service
+func WithPagination(cfg PaginationConfig) ServiceOption {
+	return func(srv *Service) {
+		srv.pagination = cfg
+	}
+}
+
+// CursorCodec return the cursor codec of service, the gRPC services can encode the page tokens by it
+func (srv *Service) CursorCodec() *CursorCodec {
+	return srv.pagination.cursor()
+}
+
+func (cfg *PaginationConfig) normalize() {
+	if cfg.DefaultSize <= 0 {
+		cfg.DefaultSize = defaultPageSize
+	}
+	if cfg.MaxSize <= 0 {
+		cfg.MaxSize = defaultMaxPageSize
+	}
+	if cfg.DefaultSize > cfg.MaxSize {
+		cfg.DefaultSize = cfg.MaxSize
+	}
+	if cfg.Cursor == nil {
+		key := make([]byte, 32)
+		rand.Read(key)
+		cfg.Cursor = NewCursorCodec(key, false)
+		cfg.randomKey = true
+	}
+}
+
+// cursor return the cursor codec, a warning is logged once if it's signed by the random key
+func (cfg *PaginationConfig) cursor() *CursorCodec {
+	if cfg.randomKey {
+		randomCursorKeyOnce.Do(func() {
+			log.Warn().Msg("The cursors are signed by a random key, they are invalid after restarting and across instances, specify PaginationConfig.Cursor")
+		})
+	}
+	return cfg.Cursor
+}
+
+// pageSize return the page size coerced by limits
+func (cfg *PaginationConfig) pageSize(size int) int {
+	if size <= 0 {
+		return cfg.DefaultSize
+	}
+	return min(size, cfg.MaxSize)
+}
+
+// paginationOf return the pagination config of the service handling ctx
+func paginationOf(ctx *Context) (*PaginationConfig, error) {
+	if ctx == nil || ctx.srv == nil {
+		return nil, errPaginationContext
+	}
+	return &ctx.srv.pagination, nil
+}
+
+// CursorCodec encodes the cursor values into opaque url-safe tokens, which are signed by HMAC-SHA256,
+// or encrypted by AES-GCM so that the clients can not read the cursor values.
+type CursorCodec struct {
+	key  []byte
+	aead cipher.AEAD
+}
+
+// NewCursorCodec create a CursorCodec by the secret key, the cursors are encrypted if encrypt is true
+func NewCursorCodec(key []byte, encrypt bool) *CursorCodec {
+	c := &CursorCodec{key: key}
+	if encrypt {
+		sum := sha256.Sum256(append([]byte("apix-cursor-encryption:"), key...))
+		block, _ := aes.NewCipher(sum[:])
+		c.aead, _ = cipher.NewGCM(block)
+	}
+	return c
+}
+
+// Encode marshals v by json and encodes it into a token
+func (c *CursorCodec) Encode(v any) (string, error) {
+	payload, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	if c.aead != nil {
+		nonce := make([]byte, c.aead.NonceSize())
+		if _, err := rand.Read(nonce); err != nil {
+			return "", err
+		}
+		return base64.RawURLEncoding.EncodeToString(c.aead.Seal(nonce, nonce, payload, nil)), nil
+	}
+	return base64.RawURLEncoding.EncodeToString(append(payload, c.sign(payload)...)), nil
+}
+
+// Decode decodes the token into v, it returns ErrInvalidCursor if the token is not encoded by the codec
+func (c *CursorCodec) Decode(token string, v any) error {
+	payload, err := c.open(token)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(payload, v); err != nil {
+		return ErrInvalidCursor
+	}
+	return nil
+}
+
+// Verify checks whether the token is encoded by the codec
+func (c *CursorCodec) Verify(token string) error {
+	_, err := c.open(token)
+	return err
+}
+
+func (c *CursorCodec) open(token string) ([]byte, error) {
+	data, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	if c.aead != nil {
+		n := c.aead.NonceSize()
+		if len(data) < n {
+			return nil, ErrInvalidCursor
+		}
+		payload, err := c.aead.Open(nil, data[:n], data[n:], nil)
+		if err != nil {
+			return nil, ErrInvalidCursor
+		}
+		return payload, nil
+	}
+	if len(data) < sha256.Size {
+		return nil, ErrInvalidCursor
+	}
+	payload, sig := data[:len(data)-sha256.Size], data[len(data)-sha256.Size:]
+	if !hmac.Equal(sig, c.sign(payload)) {
+		return nil, ErrInvalidCursor
+	}
+	return payload, nil
+}
+
+func (c *CursorCodec) sign(payload []byte) []byte {
+	mac := hmac.New(sha256.New, c.key)
+	mac.Write(payload)
+	return mac.Sum(nil)
+}
+
+// OffsetPagination is the offset based pagination parameters, embed it into the handler struct. The page is 1 if it's
+// less than 1, and the size is coerced by the PaginationConfig of service after binding.
+type OffsetPagination struct {
+	Page int `query:"page"`
+	Size int `query:"size"`
+}
+
+// normalizeParams applies the defaults and limits
+func (p *OffsetPagination) normalizeParams(ctx *Context) error {
+	if p.Page < 1 {
+		p.Page = 1
+	}
+	cfg, err := paginationOf(ctx)
+	if err != nil {
+		return err
+	}
+	p.Size = cfg.pageSize(p.Size)
+	return nil
+}
+
+// Offset return the offset of the first item in page
+func (p OffsetPagination) Offset() int {
+	return (p.Page - 1) * p.Size
+}
+
+// Limit return the max number of items in page
+func (p OffsetPagination) Limit() int {
+	return p.Size
+}
+
+// CursorPagination is the cursor based pagination parameters, embed it into the handler struct. The cursor is verified
+// and the size is coerced by the PaginationConfig of service after binding.
+type CursorPagination struct {
+	Cursor string `query:"cursor"`
+	Size   int    `query:"size"`
+}
+
+// normalizeParams applies the defaults and limits, and verifies the cursor
+func (p *CursorPagination) normalizeParams(ctx *Context) error {
+	cfg, err := paginationOf(ctx)
+	if err != nil {
+		return err
+	}
+	p.Size = cfg.pageSize(p.Size)
+	if p.Cursor != "" {
+		if err := cfg.cursor().Verify(p.Cursor); err != nil {
+			return fmt.Errorf("cursor: %w", err)
+		}
+	}
+	return nil
+}
+
+// Decode decodes the cursor into v, v is untouched if the cursor is empty(the first page)
+func (p *CursorPagination) Decode(ctx *Context, v any) error {
+	if p.Cursor == "" {
+		return nil
+	}
+	cfg, err := paginationOf(ctx)
+	if err != nil {
+		return err
+	}
+	return cfg.cursor().Decode(p.Cursor, v)
+}
+
+// PageInfo is the pagination metadata of response
+type PageInfo struct {
+	NextCursor string
+	Total      *int64
+}
+
+// Paginated is implemented by Page, the envelope renders the pagination metadata besides the items
+type Paginated interface {
+	PageItems() any
+	PageInfo() PageInfo
+}
+
+// Page is the paginated response of list handlers, the pagination metadata is rendered by envelope,
+// and the Link headers(RFC 8288) of the sibling pages are responded.
+type Page[T any] struct {
+	Items      []T    `json:"items"`
+	NextCursor string `json:"next_cursor,omitempty"`
+	Total      *int64 `json:"total,omitempty"`
+	links      []pageLink
+}
+
+type pageLink struct {
+	rel   string
+	query map[string]string
+}
+
+// NewCursorPage create the page of items, next is the cursor value of next page encoded by the cursor codec of service,
+// it's the last page if next is nil. The ctx must be the Context of handler, it returns error if ctx is nil.
+func NewCursorPage[T any](ctx *Context, items []T, next any) (*Page[T], error) {
+	p := &Page[T]{Items: items}
+	if p.Items == nil {
+		p.Items = []T{}
+	}
+	if v := reflect.ValueOf(next); !v.IsValid() || (v.Kind() == reflect.Pointer && v.IsNil()) {
+		return p, nil
+	}
+	cfg, err := paginationOf(ctx)
+	if err != nil {
+		return nil, err
+	}
+	token, err := cfg.cursor().Encode(next)
+	if err != nil {
+		return nil, err
+	}
+	p.NextCursor = token
+	p.links = append(p.links, pageLink{rel: "next", query: map[string]string{"cursor": token}})
+	return p, nil
+}
+
+// NewOffsetPage create the page of items, total is the number of all items
+func NewOffsetPage[T any](items []T, pagination OffsetPagination, total int64) *Page[T] {
+	p := &Page[T]{Items: items, Total: &total}
+	if p.Items == nil {
+		p.Items = []T{}
+	}
+	if pagination.Size <= 0 {
+		return p
+	}
+	size := strconv.Itoa(pagination.Size)
+	link := func(rel string, page int64) {
+		p.links = append(p.links, pageLink{rel: rel, query: map[string]string{"page": strconv.FormatInt(page, 10), "size": size}})
+	}
+	last := max((total+int64(pagination.Size)-1)/int64(pagination.Size), 1)
+	link("first", 1)
+	if pagination.Page > 1 {
+		link("prev", min(int64(pagination.Page-1), last))
+	}
+	if int64(pagination.Page) < last {
+		link("next", int64(pagination.Page+1))
+	}
+	link("last", last)
+	return p
+}
+
+// WithTotal set the number of all items
+func (p *Page[T]) WithTotal(total int64) *Page[T] {
+	p.Total = &total
+	return p
+}
+
+// PageItems implements the Paginated interface
+func (p *Page[T]) PageItems() any { return p.Items }
+
+// PageInfo implements the Paginated interface
+func (p *Page[T]) PageInfo() PageInfo {
+	return PageInfo{NextCursor: p.NextCursor, Total: p.Total}
+}
+
+func (p *Page[T]) pageLinks() []pageLink { return p.links }
+
+// setLinkHeader set the Link header by the page links, the urls are relative to the request url
+func setLinkHeader(w http.ResponseWriter, r *http.Request, links []pageLink) {
+	values := make([]string, 0, len(links))
+	for _, link := range links {
+		u := *r.URL
+		q := u.Query()
+		for k, v := range link.query {
+			q.Set(k, v)
+		}
+		u.RawQuery = q.Encode()
+		values = append(values, fmt.Sprintf(`<%s>; rel="%s"`, u.RequestURI(), link.rel))
+	}
+	if len(values) > 0 {
+		w.Header().Set("Link", strings.Join(values, ", "))
+	}
+}
+
+// normalizePageFields applies the pagination config on the "page_size" and "page_token" fields of gateway request
+func (cfg *PaginationConfig) normalizePageFields(msg proto.Message) error {
+	m := msg.ProtoReflect()
+	fields := m.Descriptor().Fields()
+	if fd := fields.ByName("page_size"); fd != nil && fd.Cardinality() != protoreflect.Repeated {
+		var size int64
+		switch fd.Kind() {
+		case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind, protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind:
+			size = m.Get(fd).Int()
+			if size < 0 {
+				return &queryParamError{field: "page_size", rule: RuleInvalid, err: errors.New("page_size must not be negative")}
+			}
+			size = int64(cfg.pageSize(int(size)))
+			if fd.Kind() == protoreflect.Int64Kind || fd.Kind() == protoreflect.Sint64Kind || fd.Kind() == protoreflect.Sfixed64Kind {
+				m.Set(fd, protoreflect.ValueOfInt64(size))
+			} else {
+				m.Set(fd, protoreflect.ValueOfInt32(int32(size)))
+			}
+		case protoreflect.Uint32Kind, protoreflect.Fixed32Kind:
+			m.Set(fd, protoreflect.ValueOfUint32(uint32(cfg.pageSize(int(m.Get(fd).Uint())))))
+		case protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+			m.Set(fd, protoreflect.ValueOfUint64(uint64(cfg.pageSize(int(m.Get(fd).Uint())))))
+		}
+	}
+	if fd := fields.ByName("page_token"); fd != nil && fd.Kind() == protoreflect.StringKind && fd.Cardinality() != protoreflect.Repeated {
+		if token := m.Get(fd).String(); token != "" {
+			if err := cfg.cursor().Verify(token); err != nil {
+				return &queryParamError{field: "page_token", rule: RuleInvalid, err: fmt.Errorf("page_token: %w", err)}
+			}
+		}
+	}
+	return nil
+}
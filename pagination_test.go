@@ -0,0 +1,34 @@
+package apix
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestCursorPage(t *testing.T) {
+	if _, err := NewCursorPage(nil, []int{1}, 1); !errors.Is(err, errPaginationContext) {
+		t.Errorf("got error %v for nil ctx, want %v", err, errPaginationContext)
+	}
+	if err := (&CursorPagination{Cursor: "x"}).Decode(nil, new(int)); !errors.Is(err, errPaginationContext) {
+		t.Errorf("got error %v for nil ctx, want %v", err, errPaginationContext)
+	}
+
+	key := []byte("0123456789abcdef0123456789abcdef")
+	page, err := NewCursorPage(New(WithPagination(PaginationConfig{Cursor: NewCursorCodec(key, false)})).newCtx(nil, nil, nil), []int{1, 2}, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the cursor is decoded by another instance with the same key
+	ctx := New(WithPagination(PaginationConfig{Cursor: NewCursorCodec(key, false)})).newCtx(nil, nil, nil)
+	p := &CursorPagination{Cursor: page.NextCursor}
+	if err := p.normalizeParams(ctx); err != nil {
+		t.Fatal(err)
+	}
+	var after int
+	if err := p.Decode(ctx, &after); err != nil || after != 2 {
+		t.Errorf("got cursor %d, %v, want 2", after, err)
+	}
+	if err := p.Decode(New().newCtx(nil, nil, nil), &after); !errors.Is(err, ErrInvalidCursor) {
+		t.Errorf("got error %v for the cursor of another key, want %v", err, ErrInvalidCursor)
+	}
+}
@@ -0,0 +1,620 @@
+package apix
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+const (
+	// MergePatchContentType is the content type of JSON Merge Patch(RFC 7396)
+	MergePatchContentType = "application/merge-patch+json"
+	// JSONPatchContentType is the content type of JSON Patch(RFC 6902)
+	JSONPatchContentType = "application/json-patch+json"
+)
+
+// Patch applies the request body on v by the Content-Type, JSON Patch for "application/json-patch+json", and JSON Merge
+// Patch for "application/merge-patch+json" or "application/json". It returns the paths of changed fields.
+//
+//	func (h *UpdateUser) Execute(ctx *apix.Context) (any, error) {
+//		user := loadUser(h.ID)
+//		changed, err := ctx.Patch(user)
+//		if err != nil {
+//			return nil, err
+//		}
+//		return user, saveUser(user, changed)
+//	}
+func (c *Context) Patch(v any) (FieldMask, error) {
+	ct, _, _ := mime.ParseMediaType(c.Request.Header.Get("Content-Type"))
+	switch ct {
+	case JSONPatchContentType:
+		return JSONPatch(v, c.Body())
+	case MergePatchContentType, "application/json", "":
+		return MergePatch(v, c.Body())
+	}
+	return nil, fmt.Errorf("unsupported patch content type %q", ct)
+}
+
+// MergePatch applies the JSON Merge Patch(RFC 7396) document on v, v must be a non-nil pointer. It returns the paths of
+// changed fields, the arrays are changed as a whole.
+func MergePatch(v any, patch []byte) (FieldMask, error) {
+	p, err := decodeJSONValue(patch)
+	if err != nil {
+		return nil, fmt.Errorf("invalid merge patch: %w", err)
+	}
+	return applyPatch(v, func(doc any) (any, error) {
+		return mergePatch(doc, p), nil
+	})
+}
+
+// JSONPatch applies the JSON Patch(RFC 6902) document on v, v must be a non-nil pointer. It returns the paths of
+// changed fields, the arrays are changed as a whole. None of the operations is applied if any of them failed.
+func JSONPatch(v any, patch []byte) (FieldMask, error) {
+	var ops []jsonPatchOperation
+	if err := json.Unmarshal(patch, &ops); err != nil {
+		return nil, fmt.Errorf("invalid json patch: %w", err)
+	}
+	return applyPatch(v, func(doc any) (any, error) {
+		var err error
+		for i, op := range ops {
+			if doc, err = op.apply(doc); err != nil {
+				return nil, fmt.Errorf("json patch operation %d(%s %s): %w", i, op.Op, op.Path, err)
+			}
+		}
+		return doc, nil
+	})
+}
+
+// applyPatch applies the patch on the json document of v, then decodes the patched document into v
+func applyPatch(v any, patch func(doc any) (any, error)) (FieldMask, error) {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Pointer || rv.IsNil() {
+		return nil, errors.New("patch target must be a non-nil pointer")
+	}
+	original, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	before, err := decodeJSONValue(original)
+	if err != nil {
+		return nil, err
+	}
+	doc, err := decodeJSONValue(original)
+	if err != nil {
+		return nil, err
+	}
+	if doc, err = patch(doc); err != nil {
+		return nil, err
+	}
+	patched, err := json.Marshal(doc)
+	if err != nil {
+		return nil, err
+	}
+	// decode into a copy of v, so that the fields not in json(e.g: json:"-" and unexported) are kept, and v is untouched
+	// if the decoding failed. The deleted paths are zeroed explicitly, since the decoding merges into the existing maps.
+	target := reflect.New(rv.Elem().Type())
+	target.Elem().Set(rv.Elem())
+	copyJSONValue(target.Elem())
+	for _, path := range deletedJSONPaths(nil, before, doc, nil) {
+		zeroJSONPath(target.Elem(), path)
+	}
+	dec := json.NewDecoder(bytes.NewReader(patched))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(target.Interface()); err != nil {
+		return nil, fmt.Errorf("invalid patched document: %w", err)
+	}
+	// diff by the document of decoded value, so that the no-op changes are not reported
+	buf, err := json.Marshal(target.Interface())
+	if err != nil {
+		return nil, err
+	}
+	after, err := decodeJSONValue(buf)
+	if err != nil {
+		return nil, err
+	}
+	rv.Elem().Set(target.Elem())
+	var changed FieldMask
+	diffJSONPaths("", before, after, &changed)
+	return changed, nil
+}
+
+// copyJSONValue replaces the pointers, maps and slices reachable by json in v with the copies, v must be settable
+func copyJSONValue(v reflect.Value) {
+	switch v.Kind() {
+	case reflect.Pointer:
+		if v.IsNil() {
+			return
+		}
+		p := reflect.New(v.Type().Elem())
+		p.Elem().Set(v.Elem())
+		copyJSONValue(p.Elem())
+		v.Set(p)
+	case reflect.Interface:
+		if v.IsNil() {
+			return
+		}
+		e := reflect.New(v.Elem().Type()).Elem()
+		e.Set(v.Elem())
+		copyJSONValue(e)
+		v.Set(e)
+	case reflect.Map:
+		if v.IsNil() {
+			return
+		}
+		m := reflect.MakeMapWithSize(v.Type(), v.Len())
+		for iter := v.MapRange(); iter.Next(); {
+			e := reflect.New(v.Type().Elem()).Elem()
+			e.Set(iter.Value())
+			copyJSONValue(e)
+			m.SetMapIndex(iter.Key(), e)
+		}
+		v.Set(m)
+	case reflect.Slice:
+		if v.IsNil() {
+			return
+		}
+		s := reflect.MakeSlice(v.Type(), v.Len(), v.Len())
+		reflect.Copy(s, v)
+		for i := 0; i < s.Len(); i++ {
+			copyJSONValue(s.Index(i))
+		}
+		v.Set(s)
+	case reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			copyJSONValue(v.Index(i))
+		}
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			if v.Type().Field(i).Tag.Get("json") == "-" {
+				continue
+			}
+			// the exported fields of unexported embedded struct are settable
+			if f := v.Field(i); f.CanSet() || f.Kind() == reflect.Struct {
+				copyJSONValue(f)
+			}
+		}
+	}
+}
+
+// deletedJSONPaths appends the paths of object members in a but not in b
+func deletedJSONPaths(prefix []string, a, b any, paths [][]string) [][]string {
+	switch av := a.(type) {
+	case map[string]any:
+		bv, ok := b.(map[string]any)
+		if !ok {
+			return paths
+		}
+		for k, v := range av {
+			path := append(slices.Clip(prefix), k)
+			if w, ok := bv[k]; ok {
+				paths = deletedJSONPaths(path, v, w, paths)
+			} else {
+				paths = append(paths, path)
+			}
+		}
+	case []any:
+		bv, ok := b.([]any)
+		if !ok {
+			return paths
+		}
+		for i := 0; i < len(av) && i < len(bv); i++ {
+			paths = deletedJSONPaths(append(slices.Clip(prefix), strconv.Itoa(i)), av[i], bv[i], paths)
+		}
+	}
+	return paths
+}
+
+// zeroJSONPath zeros the struct field or deletes the map entry at the json path in v
+func zeroJSONPath(v reflect.Value, path []string) {
+	for v.Kind() == reflect.Pointer || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return
+		}
+		v = v.Elem()
+	}
+	token, last := path[0], len(path) == 1
+	switch v.Kind() {
+	case reflect.Struct:
+		f, ok := jsonStructField(v, token)
+		if !ok {
+			return
+		}
+		if !last {
+			zeroJSONPath(f, path[1:])
+		} else if f.CanSet() {
+			f.SetZero()
+		}
+	case reflect.Map:
+		key, ok := jsonMapKey(v.Type().Key(), token)
+		if !ok {
+			return
+		}
+		if last {
+			v.SetMapIndex(key, reflect.Value{})
+			return
+		}
+		if e := v.MapIndex(key); e.IsValid() {
+			// the map elements are not addressable
+			c := reflect.New(e.Type()).Elem()
+			c.Set(e)
+			zeroJSONPath(c, path[1:])
+			v.SetMapIndex(key, c)
+		}
+	case reflect.Slice, reflect.Array:
+		if i, err := strconv.Atoi(token); err == nil && i >= 0 && i < v.Len() && !last {
+			zeroJSONPath(v.Index(i), path[1:])
+		}
+	}
+}
+
+// jsonStructField return the field of struct v by the json name, the embedded structs are flattened as encoding/json
+func jsonStructField(v reflect.Value, name string) (reflect.Value, bool) {
+	var folded reflect.Value
+	for i := 0; i < v.NumField(); i++ {
+		sf := v.Type().Field(i)
+		tag, _, _ := strings.Cut(sf.Tag.Get("json"), ",")
+		if sf.Tag.Get("json") == "-" {
+			continue
+		}
+		if sf.Anonymous && tag == "" {
+			f := v.Field(i)
+			if f.Kind() == reflect.Pointer {
+				if f.IsNil() {
+					continue
+				}
+				f = f.Elem()
+			}
+			if f.Kind() == reflect.Struct {
+				if ef, ok := jsonStructField(f, name); ok {
+					return ef, true
+				}
+				continue
+			}
+		}
+		if !sf.IsExported() {
+			continue
+		}
+		if tag == "" {
+			tag = sf.Name
+		}
+		if tag == name {
+			return v.Field(i), true
+		}
+		if !folded.IsValid() && strings.EqualFold(tag, name) {
+			folded = v.Field(i)
+		}
+	}
+	return folded, folded.IsValid()
+}
+
+// jsonMapKey converts the json object key into the map key of type t
+func jsonMapKey(t reflect.Type, key string) (reflect.Value, bool) {
+	if t.Kind() == reflect.String {
+		return reflect.ValueOf(key).Convert(t), true
+	}
+	if reflect.PointerTo(t).Implements(reflect.TypeFor[encoding.TextUnmarshaler]()) {
+		k := reflect.New(t)
+		if err := k.Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(key)); err != nil {
+			return reflect.Value{}, false
+		}
+		return k.Elem(), true
+	}
+	switch t.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(key, 10, t.Bits())
+		if err != nil {
+			return reflect.Value{}, false
+		}
+		return reflect.ValueOf(n).Convert(t), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		n, err := strconv.ParseUint(key, 10, t.Bits())
+		if err != nil {
+			return reflect.Value{}, false
+		}
+		return reflect.ValueOf(n).Convert(t), true
+	}
+	return reflect.Value{}, false
+}
+
+func mergePatch(target, patch any) any {
+	p, ok := patch.(map[string]any)
+	if !ok {
+		return patch
+	}
+	t, ok := target.(map[string]any)
+	if !ok {
+		t = map[string]any{}
+	}
+	for k, v := range p {
+		if v == nil {
+			delete(t, k)
+		} else {
+			t[k] = mergePatch(t[k], v)
+		}
+	}
+	return t
+}
+
+// diffJSONPaths appends the paths of different values between a and b
+func diffJSONPaths(prefix string, a, b any, paths *FieldMask) {
+	am, aok := a.(map[string]any)
+	bm, bok := b.(map[string]any)
+	if !aok || !bok {
+		if !jsonEqual(a, b) && prefix != "" {
+			*paths = append(*paths, prefix)
+		}
+		return
+	}
+	keys := make([]string, 0, len(am)+len(bm))
+	for k := range am {
+		keys = append(keys, k)
+	}
+	for k := range bm {
+		if _, ok := am[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	slices.Sort(keys)
+	for _, k := range keys {
+		diffJSONPaths(joinFilterPath(prefix, k), am[k], bm[k], paths)
+	}
+}
+
+// jsonEqual compares the generic json values, the numbers are compared by value
+func jsonEqual(a, b any) bool {
+	switch av := a.(type) {
+	case json.Number:
+		bv, ok := b.(json.Number)
+		if !ok {
+			return false
+		}
+		if av == bv {
+			return true
+		}
+		af, aerr := av.Float64()
+		bf, berr := bv.Float64()
+		return aerr == nil && berr == nil && af == bf
+	case map[string]any:
+		bv, ok := b.(map[string]any)
+		if !ok || len(av) != len(bv) {
+			return false
+		}
+		for k, v := range av {
+			if w, ok := bv[k]; !ok || !jsonEqual(v, w) {
+				return false
+			}
+		}
+		return true
+	case []any:
+		bv, ok := b.([]any)
+		if !ok || len(av) != len(bv) {
+			return false
+		}
+		for i := range av {
+			if !jsonEqual(av[i], bv[i]) {
+				return false
+			}
+		}
+		return true
+	}
+	return a == b
+}
+
+type jsonPatchOperation struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	From  string          `json:"from"`
+	Value json.RawMessage `json:"value"`
+}
+
+func (op *jsonPatchOperation) value() (any, error) {
+	if op.Value == nil {
+		return nil, errors.New("value required")
+	}
+	return decodeJSONValue(op.Value)
+}
+
+func (op *jsonPatchOperation) apply(doc any) (any, error) {
+	path, err := parseJSONPointer(op.Path)
+	if err != nil {
+		return nil, err
+	}
+	switch op.Op {
+	case "add":
+		value, err := op.value()
+		if err != nil {
+			return nil, err
+		}
+		return jsonAdd(doc, path, value)
+	case "remove":
+		doc, _, err = jsonRemove(doc, path)
+		return doc, err
+	case "replace":
+		value, err := op.value()
+		if err != nil {
+			return nil, err
+		}
+		if _, err := jsonGet(doc, path); err != nil {
+			return nil, err
+		}
+		if doc, _, err = jsonRemove(doc, path); err != nil && len(path) > 0 {
+			return nil, err
+		}
+		return jsonAdd(doc, path, value)
+	case "move", "copy":
+		from, err := parseJSONPointer(op.From)
+		if err != nil {
+			return nil, err
+		}
+		var value any
+		if op.Op == "move" {
+			if len(path) > len(from) && slices.Equal(path[:len(from)], from) {
+				return nil, errors.New("can not move a value into its child")
+			}
+			if doc, value, err = jsonRemove(doc, from); err != nil {
+				return nil, err
+			}
+		} else {
+			if value, err = jsonGet(doc, from); err != nil {
+				return nil, err
+			}
+			// deep copy the value, so that the later operations on it do not change the source
+			buf, _ := json.Marshal(value)
+			value, _ = decodeJSONValue(buf)
+		}
+		return jsonAdd(doc, path, value)
+	case "test":
+		value, err := op.value()
+		if err != nil {
+			return nil, err
+		}
+		actual, err := jsonGet(doc, path)
+		if err != nil {
+			return nil, err
+		}
+		if !jsonEqual(actual, value) {
+			return nil, errors.New("test failed")
+		}
+		return doc, nil
+	}
+	return nil, fmt.Errorf("unknown operation %q", op.Op)
+}
+
+// parseJSONPointer parses the JSON Pointer(RFC 6901) into the reference tokens
+func parseJSONPointer(pointer string) ([]string, error) {
+	if pointer == "" {
+		return nil, nil
+	}
+	if !strings.HasPrefix(pointer, "/") {
+		return nil, fmt.Errorf("invalid json pointer %q", pointer)
+	}
+	tokens := strings.Split(pointer[1:], "/")
+	for i, t := range tokens {
+		tokens[i] = strings.ReplaceAll(strings.ReplaceAll(t, "~1", "/"), "~0", "~")
+	}
+	return tokens, nil
+}
+
+func arrayIndex(token string, length int, appendable bool) (int, error) {
+	if token == "-" && appendable {
+		return length, nil
+	}
+	i, err := strconv.Atoi(token)
+	if err != nil || i < 0 || (token != "0" && strings.HasPrefix(token, "0")) {
+		return 0, fmt.Errorf("invalid array index %q", token)
+	}
+	if i > length || (i == length && !appendable) {
+		return 0, fmt.Errorf("array index %d out of range", i)
+	}
+	return i, nil
+}
+
+func jsonGet(doc any, path []string) (any, error) {
+	for _, token := range path {
+		switch node := doc.(type) {
+		case map[string]any:
+			v, ok := node[token]
+			if !ok {
+				return nil, fmt.Errorf("path %q not found", token)
+			}
+			doc = v
+		case []any:
+			i, err := arrayIndex(token, len(node), false)
+			if err != nil {
+				return nil, err
+			}
+			doc = node[i]
+		default:
+			return nil, fmt.Errorf("path %q not found", token)
+		}
+	}
+	return doc, nil
+}
+
+// jsonUpdate applies fn on the parent of the last token, and returns the updated document
+func jsonUpdate(doc any, path []string, fn func(parent any, token string) (any, error)) (any, error) {
+	if len(path) == 1 {
+		return fn(doc, path[0])
+	}
+	switch node := doc.(type) {
+	case map[string]any:
+		child, ok := node[path[0]]
+		if !ok {
+			return nil, fmt.Errorf("path %q not found", path[0])
+		}
+		child, err := jsonUpdate(child, path[1:], fn)
+		if err != nil {
+			return nil, err
+		}
+		node[path[0]] = child
+		return node, nil
+	case []any:
+		i, err := arrayIndex(path[0], len(node), false)
+		if err != nil {
+			return nil, err
+		}
+		child, err := jsonUpdate(node[i], path[1:], fn)
+		if err != nil {
+			return nil, err
+		}
+		node[i] = child
+		return node, nil
+	}
+	return nil, fmt.Errorf("path %q not found", path[0])
+}
+
+func jsonAdd(doc any, path []string, value any) (any, error) {
+	if len(path) == 0 {
+		return value, nil
+	}
+	return jsonUpdate(doc, path, func(parent any, token string) (any, error) {
+		switch node := parent.(type) {
+		case map[string]any:
+			node[token] = value
+			return node, nil
+		case []any:
+			i, err := arrayIndex(token, len(node), true)
+			if err != nil {
+				return nil, err
+			}
+			return slices.Insert(node, i, value), nil
+		}
+		return nil, fmt.Errorf("path %q not found", token)
+	})
+}
+
+// jsonRemove removes the value at path, and returns the updated document and the removed value
+func jsonRemove(doc any, path []string) (any, any, error) {
+	if len(path) == 0 {
+		return nil, nil, errors.New("can not remove the root")
+	}
+	var removed any
+	doc, err := jsonUpdate(doc, path, func(parent any, token string) (any, error) {
+		switch node := parent.(type) {
+		case map[string]any:
+			v, ok := node[token]
+			if !ok {
+				return nil, fmt.Errorf("path %q not found", token)
+			}
+			removed = v
+			delete(node, token)
+			return node, nil
+		case []any:
+			i, err := arrayIndex(token, len(node), false)
+			if err != nil {
+				return nil, err
+			}
+			removed = node[i]
+			return slices.Delete(node, i, i+1), nil
+		}
+		return nil, fmt.Errorf("path %q not found", token)
+	})
+	return doc, removed, err
+}
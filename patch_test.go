@@ -0,0 +1,284 @@
+package apix
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+type patchAuthor struct {
+	Name  string `json:"name"`
+	Email string `json:"email,omitempty"`
+	token string
+}
+
+type patchItem struct {
+	SKU   string `json:"sku"`
+	Count int    `json:"count,omitempty"`
+}
+
+type patchDoc struct {
+	ID      int               `json:"id"`
+	Title   string            `json:"title"`
+	Author  *patchAuthor      `json:"author,omitempty"`
+	Labels  map[string]string `json:"labels,omitempty"`
+	Scores  map[int]int       `json:"scores,omitempty"`
+	Items   []patchItem       `json:"items"`
+	Tags    []string          `json:"tags"`
+	Version int               `json:"-"`
+	secret  string
+}
+
+func newPatchDoc() *patchDoc {
+	return &patchDoc{
+		ID:      1,
+		Title:   "hello",
+		Author:  &patchAuthor{Name: "alice", Email: "a@example.com", token: "t"},
+		Labels:  map[string]string{"env": "prod", "team": "api"},
+		Scores:  map[int]int{1: 10, 2: 20},
+		Items:   []patchItem{{SKU: "a", Count: 1}, {SKU: "b", Count: 2}},
+		Tags:    []string{"x", "y"},
+		Version: 7,
+		secret:  "s",
+	}
+}
+
+func TestMergePatchStruct(t *testing.T) {
+	tests := []struct {
+		name    string
+		patch   string
+		want    func(d *patchDoc)
+		changed FieldMask
+	}{
+		{"empty", `{}`, func(d *patchDoc) {}, nil},
+		{"no-op", `{"title":"hello","id":1}`, func(d *patchDoc) {}, nil},
+		{"field", `{"title":"world"}`, func(d *patchDoc) { d.Title = "world" }, FieldMask{"title"}},
+		{"nested", `{"author":{"email":null}}`, func(d *patchDoc) { d.Author.Email = "" }, FieldMask{"author.email"}},
+		{"pointer to null", `{"author":null}`, func(d *patchDoc) { d.Author = nil }, FieldMask{"author"}},
+		{"map key deleted", `{"labels":{"env":null,"owner":"bob"}}`, func(d *patchDoc) {
+			d.Labels = map[string]string{"team": "api", "owner": "bob"}
+		}, FieldMask{"labels.env", "labels.owner"}},
+		{"map to null", `{"labels":null}`, func(d *patchDoc) { d.Labels = nil }, FieldMask{"labels"}},
+		{"int map key deleted", `{"scores":{"1":null}}`, func(d *patchDoc) { d.Scores = map[int]int{2: 20} }, FieldMask{"scores.1"}},
+		{"slice replaced", `{"items":[{"sku":"c"}]}`, func(d *patchDoc) { d.Items = []patchItem{{SKU: "c"}} }, FieldMask{"items"}},
+		{"slice grown", `{"tags":["x","y","z"]}`, func(d *patchDoc) { d.Tags = []string{"x", "y", "z"} }, FieldMask{"tags"}},
+		{"slice to null", `{"tags":null}`, func(d *patchDoc) { d.Tags = nil }, FieldMask{"tags"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d, want := newPatchDoc(), newPatchDoc()
+			tt.want(want)
+			changed, err := MergePatch(d, []byte(tt.patch))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(d, want) {
+				t.Errorf("got %+v, want %+v", d, want)
+			}
+			if !reflect.DeepEqual(changed, tt.changed) {
+				t.Errorf("got changed %v, want %v", changed, tt.changed)
+			}
+		})
+	}
+}
+
+func TestJSONPatchStruct(t *testing.T) {
+	tests := []struct {
+		name    string
+		patch   string
+		want    func(d *patchDoc)
+		changed FieldMask
+	}{
+		{"remove field", `[{"op":"remove","path":"/author/email"}]`, func(d *patchDoc) { d.Author.Email = "" }, FieldMask{"author.email"}},
+		{"remove map key", `[{"op":"remove","path":"/labels/env"}]`, func(d *patchDoc) { delete(d.Labels, "env") }, FieldMask{"labels.env"}},
+		{"remove nested in array", `[{"op":"remove","path":"/items/0/count"}]`, func(d *patchDoc) { d.Items[0].Count = 0 }, FieldMask{"items"}},
+		{"remove array element", `[{"op":"remove","path":"/items/0"}]`, func(d *patchDoc) { d.Items = d.Items[1:] }, FieldMask{"items"}},
+		{"append", `[{"op":"add","path":"/tags/-","value":"z"}]`, func(d *patchDoc) { d.Tags = append(d.Tags, "z") }, FieldMask{"tags"}},
+		{"move", `[{"op":"move","from":"/labels/env","path":"/labels/stage"}]`, func(d *patchDoc) {
+			d.Labels = map[string]string{"stage": "prod", "team": "api"}
+		}, FieldMask{"labels.env", "labels.stage"}},
+		{"copy", `[{"op":"copy","from":"/items/1","path":"/items/0"}]`, func(d *patchDoc) {
+			d.Items = []patchItem{{SKU: "b", Count: 2}, {SKU: "a", Count: 1}, {SKU: "b", Count: 2}}
+		}, FieldMask{"items"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d, want := newPatchDoc(), newPatchDoc()
+			tt.want(want)
+			changed, err := JSONPatch(d, []byte(tt.patch))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(d, want) {
+				t.Errorf("got %+v, want %+v", d, want)
+			}
+			if !reflect.DeepEqual(changed, tt.changed) {
+				t.Errorf("got changed %v, want %v", changed, tt.changed)
+			}
+		})
+	}
+}
+
+func TestPatchStructFailure(t *testing.T) {
+	tests := []struct {
+		name  string
+		patch func(v any) error
+	}{
+		{"unknown field", func(v any) error {
+			_, err := MergePatch(v, []byte(`{"unknown":1}`))
+			return err
+		}},
+		{"type mismatch", func(v any) error {
+			_, err := MergePatch(v, []byte(`{"labels":{"new":"v","team":1},"author":{"name":2}}`))
+			return err
+		}},
+		{"failed operation", func(v any) error {
+			_, err := JSONPatch(v, []byte(`[{"op":"add","path":"/title","value":"x"},{"op":"remove","path":"/missing"}]`))
+			return err
+		}},
+		{"failed decoding", func(v any) error {
+			_, err := JSONPatch(v, []byte(`[{"op":"add","path":"/items/0/sku","value":"c"},{"op":"add","path":"/tags/0","value":1}]`))
+			return err
+		}},
+		{"not pointer", func(v any) error {
+			_, err := MergePatch(*v.(*patchDoc), []byte(`{}`))
+			return err
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := newPatchDoc()
+			if err := tt.patch(d); err == nil {
+				t.Fatal("want error")
+			}
+			if want := newPatchDoc(); !reflect.DeepEqual(d, want) {
+				t.Errorf("got %+v, want untouched %+v", d, want)
+			}
+		})
+	}
+}
+
+func TestJSONPatchRFC6902(t *testing.T) {
+	tests := []struct {
+		name  string
+		doc   string
+		patch string
+		want  string // empty for error
+	}{
+		// Appendix A
+		{"A.1", `{"foo":"bar"}`, `[{"op":"add","path":"/baz","value":"qux"}]`, `{"baz":"qux","foo":"bar"}`},
+		{"A.2", `{"foo":["bar","baz"]}`, `[{"op":"add","path":"/foo/1","value":"qux"}]`, `{"foo":["bar","qux","baz"]}`},
+		{"A.3", `{"baz":"qux","foo":"bar"}`, `[{"op":"remove","path":"/baz"}]`, `{"foo":"bar"}`},
+		{"A.4", `{"foo":["bar","qux","baz"]}`, `[{"op":"remove","path":"/foo/1"}]`, `{"foo":["bar","baz"]}`},
+		{"A.5", `{"baz":"qux","foo":"bar"}`, `[{"op":"replace","path":"/baz","value":"boo"}]`, `{"baz":"boo","foo":"bar"}`},
+		{"A.6", `{"foo":{"bar":"baz","waldo":"fred"},"qux":{"corge":"grault"}}`,
+			`[{"op":"move","from":"/foo/waldo","path":"/qux/thud"}]`,
+			`{"foo":{"bar":"baz"},"qux":{"corge":"grault","thud":"fred"}}`},
+		{"A.7", `{"foo":["all","grass","cows","eat"]}`, `[{"op":"move","from":"/foo/1","path":"/foo/3"}]`,
+			`{"foo":["all","cows","eat","grass"]}`},
+		{"A.8", `{"baz":"qux","foo":["a",2,"c"]}`,
+			`[{"op":"test","path":"/baz","value":"qux"},{"op":"test","path":"/foo/1","value":2}]`,
+			`{"baz":"qux","foo":["a",2,"c"]}`},
+		{"A.9", `{"baz":"qux"}`, `[{"op":"test","path":"/baz","value":"bar"}]`, ``},
+		{"A.10", `{"foo":"bar"}`, `[{"op":"add","path":"/child","value":{"grandchild":{}}}]`,
+			`{"foo":"bar","child":{"grandchild":{}}}`},
+		{"A.11", `{"foo":"bar"}`, `[{"op":"add","path":"/baz","value":"qux","xyz":123}]`, `{"foo":"bar","baz":"qux"}`},
+		{"A.12", `{"foo":"bar"}`, `[{"op":"add","path":"/baz/bat","value":"qux"}]`, ``},
+		{"A.13", `{"foo":"bar"}`, `[{"op":"add","path":"/baz","value":"qux","op":"remove"}]`, ``},
+		{"A.14", `{"/":9,"~1":10}`, `[{"op":"test","path":"/~01","value":10}]`, `{"/":9,"~1":10}`},
+		{"A.15", `{"/":9,"~1":10}`, `[{"op":"test","path":"/~01","value":"10"}]`, ``},
+		{"A.16", `{"foo":["bar"]}`, `[{"op":"add","path":"/foo/-","value":["abc","def"]}]`, `{"foo":["bar",["abc","def"]]}`},
+		// edge cases
+		{"move into itself", `{"a":{"b":1}}`, `[{"op":"move","from":"/a","path":"/a/c"}]`, ``},
+		{"move to the same path", `{"a":{"b":1}}`, `[{"op":"move","from":"/a","path":"/a"}]`, `{"a":{"b":1}}`},
+		{"move to the sibling prefix", `{"a":1}`, `[{"op":"move","from":"/a","path":"/ab"}]`, `{"ab":1}`},
+		{"add at -", `{"a":[]}`, `[{"op":"add","path":"/a/-","value":1},{"op":"add","path":"/a/-","value":2}]`, `{"a":[1,2]}`},
+		{"remove at -", `{"a":[1]}`, `[{"op":"remove","path":"/a/-"}]`, ``},
+		{"replace at -", `{"a":[1]}`, `[{"op":"replace","path":"/a/-","value":2}]`, ``},
+		{"test at -", `{"a":[1]}`, `[{"op":"test","path":"/a/-","value":1}]`, ``},
+		{"add out of range", `{"a":[1]}`, `[{"op":"add","path":"/a/2","value":2}]`, ``},
+		{"add at leading zero", `{"a":[1,2]}`, `[{"op":"add","path":"/a/01","value":2}]`, ``},
+		{"replace the root", `{"a":1}`, `[{"op":"replace","path":"","value":{"b":2}}]`, `{"b":2}`},
+		{"add the root", `{"a":1}`, `[{"op":"add","path":"","value":[1]}]`, `[1]`},
+		{"remove the root", `{"a":1}`, `[{"op":"remove","path":""}]`, ``},
+		{"test the root", `{"a":1}`, `[{"op":"test","path":"","value":{"a":1.0}}]`, `{"a":1}`},
+		{"replace missing", `{"a":1}`, `[{"op":"replace","path":"/b","value":2}]`, ``},
+		{"value required", `{"a":1}`, `[{"op":"add","path":"/b"}]`, ``},
+		{"null value", `{"a":1}`, `[{"op":"add","path":"/b","value":null}]`, `{"a":1,"b":null}`},
+		{"copy is deep", `{"a":{"b":1}}`, `[{"op":"copy","from":"/a","path":"/c"},{"op":"add","path":"/c/b","value":2}]`,
+			`{"a":{"b":1},"c":{"b":2}}`},
+		{"invalid pointer", `{"a":1}`, `[{"op":"add","path":"a","value":2}]`, ``},
+		{"unknown operation", `{"a":1}`, `[{"op":"merge","path":"/a","value":2}]`, ``},
+		{"all or nothing", `{"a":1}`, `[{"op":"add","path":"/b","value":2},{"op":"test","path":"/a","value":2}]`, ``},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var doc any
+			if err := json.Unmarshal([]byte(tt.doc), &doc); err != nil {
+				t.Fatal(err)
+			}
+			_, err := JSONPatch(&doc, []byte(tt.patch))
+			want := tt.want
+			if want == "" {
+				if err == nil {
+					t.Fatal("want error")
+				}
+				want = tt.doc
+			} else if err != nil {
+				t.Fatal(err)
+			}
+			assertJSONEqual(t, doc, want)
+		})
+	}
+}
+
+func TestMergePatchRFC7396(t *testing.T) {
+	// Appendix A
+	tests := []struct {
+		doc, patch, want string
+	}{
+		{`{"a":"b"}`, `{"a":"c"}`, `{"a":"c"}`},
+		{`{"a":"b"}`, `{"b":"c"}`, `{"a":"b","b":"c"}`},
+		{`{"a":"b"}`, `{"a":null}`, `{}`},
+		{`{"a":"b","b":"c"}`, `{"a":null}`, `{"b":"c"}`},
+		{`{"a":["b"]}`, `{"a":"c"}`, `{"a":"c"}`},
+		{`{"a":"c"}`, `{"a":["b"]}`, `{"a":["b"]}`},
+		{`{"a":{"b":"c"}}`, `{"a":{"b":"d","c":null}}`, `{"a":{"b":"d"}}`},
+		{`{"a":[{"b":"c"}]}`, `{"a":[1]}`, `{"a":[1]}`},
+		{`["a","b"]`, `["c","d"]`, `["c","d"]`},
+		{`{"a":"b"}`, `["c"]`, `["c"]`},
+		{`{"a":"foo"}`, `null`, `null`},
+		{`{"a":"foo"}`, `"bar"`, `"bar"`},
+		{`{"e":null}`, `{"a":1}`, `{"e":null,"a":1}`},
+		{`[1,2]`, `{"a":"b","c":null}`, `{"a":"b"}`},
+		{`{}`, `{"a":{"bb":{"ccc":null}}}`, `{"a":{"bb":{}}}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.doc+" "+tt.patch, func(t *testing.T) {
+			var doc any
+			if err := json.Unmarshal([]byte(tt.doc), &doc); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := MergePatch(&doc, []byte(tt.patch)); err != nil {
+				t.Fatal(err)
+			}
+			assertJSONEqual(t, doc, tt.want)
+		})
+	}
+}
+
+func assertJSONEqual(t *testing.T, v any, want string) {
+	t.Helper()
+	buf, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, _ := decodeJSONValue(buf)
+	expected, err := decodeJSONValue([]byte(want))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !jsonEqual(got, expected) {
+		t.Errorf("got %s, want %s", buf, want)
+	}
+}
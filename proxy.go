@@ -0,0 +1,256 @@
+package apix
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	defaultProxyFailureThreshold = 3
+	defaultProxyCooldown         = 10 * time.Second
+)
+
+// ProxyOptions configures the reverse proxy route
+type ProxyOptions struct {
+	// StripPrefix is removed from the request path before proxying
+	StripPrefix string
+	// Rewrite rewrites the request path(after StripPrefix) before proxying
+	Rewrite func(path string) string
+	// Headers is injected into the upstream requests
+	Headers map[string]string
+	// Timeout limits the duration of each upstream attempt, no timeout if it's zero
+	Timeout time.Duration
+	// Retries is the max retry times on other targets for idempotent methods(GET, HEAD, OPTIONS, PUT, DELETE),
+	// when the connection failed or upstream responses 502, 503 or 504
+	Retries int
+	// FailureThreshold is the consecutive failures marking a target unhealthy, default 3
+	FailureThreshold int
+	// Cooldown is the duration an unhealthy target is skipped, default 10s
+	Cooldown time.Duration
+	// Transport is the http.RoundTripper for upstream requests, http.DefaultTransport is used if nil
+	Transport http.RoundTripper
+}
+
+// Proxy forwards the requests matching pattern to the targets by reverse proxy, the targets are load balanced by round-robin
+// and skipped for a while after consecutive failures(passive health check). The proxy route works with middlewares, route
+// options and access log just like the native routes, so the endpoints can be migrated from legacy service one by one.
+//
+//	srv.Proxy("/v1/orders/", []string{"http://legacy-1:8080", "http://legacy-2:8080"}, apix.ProxyOptions{Retries: 1})
+func (srv *Service) Proxy(pattern string, targets []string, opts ProxyOptions, routeOpts ...RouteOption) {
+	method, pattern := splitPatternMethod(pattern)
+	srv.handle(method, pattern, newProxyHandler(targets, opts), srv.middlewares, routeOpts)
+}
+
+// Proxy forwards the requests matching pattern under the group prefix to the targets by reverse proxy
+func (g *Group) Proxy(p string, targets []string, opts ProxyOptions, routeOpts ...RouteOption) {
+	method, p := splitPatternMethod(p)
+	g.handle(method, singleJoiningSlash(g.prefix, p), newProxyHandler(targets, opts), routeOpts)
+}
+
+// splitPatternMethod split the optional method from the pattern, e.g: "GET /orders/" to "GET" and "/orders/"
+func splitPatternMethod(pattern string) (string, string) {
+	if method, p, ok := strings.Cut(pattern, " "); ok {
+		return method, strings.TrimLeft(p, " \t")
+	}
+	return "", pattern
+}
+
+type proxyTarget struct {
+	url       *url.URL
+	failures  atomic.Int32
+	downUntil atomic.Int64
+}
+
+type proxyHandler struct {
+	opts    ProxyOptions
+	targets []*proxyTarget
+	next    atomic.Uint32
+	proxy   *httputil.ReverseProxy
+}
+
+func newProxyHandler(targets []string, opts ProxyOptions) *proxyHandler {
+	if len(targets) == 0 {
+		panic("proxy targets required")
+	}
+	if opts.FailureThreshold <= 0 {
+		opts.FailureThreshold = defaultProxyFailureThreshold
+	}
+	if opts.Cooldown <= 0 {
+		opts.Cooldown = defaultProxyCooldown
+	}
+	if opts.Transport == nil {
+		opts.Transport = http.DefaultTransport
+	}
+	ph := &proxyHandler{opts: opts}
+	for _, target := range targets {
+		u, err := url.Parse(target)
+		if err != nil || u.Scheme == "" || u.Host == "" {
+			panic(fmt.Sprintf("invalid proxy target %q", target))
+		}
+		ph.targets = append(ph.targets, &proxyTarget{url: u})
+	}
+	ph.proxy = &httputil.ReverseProxy{
+		Rewrite:   ph.rewrite,
+		Transport: ph,
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			log.Ctx(r.Context()).Error().Err(err).Str("method", r.Method).Str("path", r.URL.Path).Msg("Proxying request error")
+			status := http.StatusBadGateway
+			if err == context.DeadlineExceeded {
+				status = http.StatusGatewayTimeout
+			}
+			ReturnJSON(w, status, ResponseBody{Code: status, Message: err.Error()})
+		},
+	}
+	return ph
+}
+
+func (ph *proxyHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ph.proxy.ServeHTTP(w, r)
+}
+
+// rewrite the path and headers of the outgoing request, the target is chosen in RoundTrip for retrying
+func (ph *proxyHandler) rewrite(pr *httputil.ProxyRequest) {
+	pr.SetXForwarded()
+	out := pr.Out
+	p := out.URL.Path
+	if ph.opts.StripPrefix != "" {
+		p = "/" + strings.TrimPrefix(strings.TrimPrefix(p, ph.opts.StripPrefix), "/")
+	}
+	if ph.opts.Rewrite != nil {
+		p = ph.opts.Rewrite(p)
+	}
+	out.URL.Path, out.URL.RawPath = p, ""
+	for k, v := range ph.opts.Headers {
+		out.Header.Set(k, v)
+	}
+}
+
+// RoundTrip implements the http.RoundTripper, it sends the request to a healthy target, and retries on others for idempotent requests
+func (ph *proxyHandler) RoundTrip(req *http.Request) (*http.Response, error) {
+	attempts := 1
+	if isIdempotent(req.Method) && (req.Body == nil || req.Body == http.NoBody || req.GetBody != nil) {
+		attempts += ph.opts.Retries
+	}
+	var (
+		resp *http.Response
+		err  error
+	)
+	for i := 0; i < attempts; i++ {
+		target := ph.pick()
+		resp, err = ph.roundTrip(req, target)
+		failed := err != nil || resp.StatusCode == http.StatusBadGateway ||
+			resp.StatusCode == http.StatusServiceUnavailable || resp.StatusCode == http.StatusGatewayTimeout
+		if err == nil || !errors.Is(req.Context().Err(), context.Canceled) {
+			// the cancellation of client is not the failure of target
+			ph.report(target, !failed)
+		}
+		if !failed || i == attempts-1 || req.Context().Err() != nil {
+			break
+		}
+		if resp != nil {
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+		}
+		if req.GetBody != nil {
+			if req.Body, err = req.GetBody(); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return resp, err
+}
+
+func (ph *proxyHandler) roundTrip(req *http.Request, target *proxyTarget) (*http.Response, error) {
+	ctx, cancel := req.Context(), context.CancelFunc(func() {})
+	if ph.opts.Timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, ph.opts.Timeout)
+	}
+	out := req.Clone(ctx)
+	out.URL.Scheme = target.url.Scheme
+	out.URL.Host = target.url.Host
+	out.URL.Path = singleJoiningSlash(target.url.Path, req.URL.Path)
+	if target.url.RawQuery != "" {
+		out.URL.RawQuery = target.url.RawQuery + "&" + req.URL.RawQuery
+	}
+	out.Host = ""
+	resp, err := ph.opts.Transport.RoundTrip(out)
+	if err != nil {
+		cancel()
+		if ctx.Err() == context.DeadlineExceeded {
+			err = context.DeadlineExceeded
+		}
+		return nil, err
+	}
+	// cancel the timeout after the response body consumed
+	resp.Body = &cancelOnClose{ReadCloser: resp.Body, cancel: cancel}
+	return resp, nil
+}
+
+// pick choose the next healthy target by round-robin, all the targets are considered healthy if none of them is
+func (ph *proxyHandler) pick() *proxyTarget {
+	var (
+		n     = uint32(len(ph.targets))
+		start = ph.next.Add(1)
+		now   = time.Now().UnixNano()
+	)
+	for i := uint32(0); i < n; i++ {
+		target := ph.targets[(start+i)%n]
+		if target.downUntil.Load() <= now {
+			return target
+		}
+	}
+	return ph.targets[start%n]
+}
+
+// report records the result of target, it's marked unhealthy for a cooldown after consecutive failures
+func (ph *proxyHandler) report(target *proxyTarget, ok bool) {
+	if ok {
+		target.failures.Store(0)
+		return
+	}
+	if target.failures.Add(1) >= int32(ph.opts.FailureThreshold) {
+		target.downUntil.Store(time.Now().Add(ph.opts.Cooldown).UnixNano())
+		target.failures.Store(0)
+		log.Warn().Str("target", target.url.String()).Dur("cooldown", ph.opts.Cooldown).Msg("Proxy target marked unhealthy")
+	}
+}
+
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (c *cancelOnClose) Close() error {
+	defer c.cancel()
+	return c.ReadCloser.Close()
+}
+
+func isIdempotent(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodPut, http.MethodDelete, http.MethodTrace:
+		return true
+	}
+	return false
+}
+
+func singleJoiningSlash(a, b string) string {
+	aslash := strings.HasSuffix(a, "/")
+	bslash := strings.HasPrefix(b, "/")
+	switch {
+	case aslash && bslash:
+		return a + b[1:]
+	case !aslash && !bslash:
+		return a + "/" + b
+	}
+	return a + b
+}
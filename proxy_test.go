@@ -0,0 +1,40 @@
+package apix
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestProxyRoutePattern(t *testing.T) {
+	srv := New()
+	srv.Proxy("GET /api/{path...}", []string{"http://127.0.0.1:1"}, ProxyOptions{})
+	srv.GROUP("/v1").Proxy("POST /orders/", []string{"http://127.0.0.1:1"}, ProxyOptions{})
+	srv.Proxy("/legacy/", []string{"http://127.0.0.1:1"}, ProxyOptions{})
+	want := []Route{{Method: "GET", Pattern: "/api/{path...}"}, {Method: "POST", Pattern: "/v1/orders/"}, {Pattern: "/legacy/"}}
+	routes := srv.Routes()
+	if len(routes) != len(want) {
+		t.Fatalf("got %d routes, want %d", len(routes), len(want))
+	}
+	for i, r := range routes {
+		if r.Method != want[i].Method || r.Pattern != want[i].Pattern {
+			t.Errorf("got route %q %q, want %q %q", r.Method, r.Pattern, want[i].Method, want[i].Pattern)
+		}
+	}
+}
+
+func TestProxyClientCancellation(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cancel()
+		<-r.Context().Done()
+	}))
+	defer upstream.Close()
+
+	ph := newProxyHandler([]string{upstream.URL}, ProxyOptions{FailureThreshold: 1})
+	ph.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil).WithContext(ctx))
+	if target := ph.targets[0]; target.failures.Load() != 0 || target.downUntil.Load() != 0 {
+		t.Error("the target should not be marked unhealthy by the cancellation of client")
+	}
+}
@@ -6,9 +6,11 @@ import (
 	"errors"
 	"fmt"
 	"net/url"
+	"reflect"
 	"regexp"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/cloudfly/timex"
@@ -31,12 +33,18 @@ var valuesKeyRegexp = regexp.MustCompile(`^(.*)\[(.*)\]$`)
 // query parameters parsing behavior.
 //
 // See https://github.com/grpc-ecosystem/grpc-gateway/issues/2632 for more context.
-type queryParser struct{}
+type queryParser struct {
+	// pagination applies the page size limits and verifies the page token of list requests, it's nil if disabled
+	pagination *PaginationConfig
+	// errs keeps the *queryParamError of the forms failed in parsing, keyed by the identity of form map, since the generated
+	// gateway handlers only pass the message of error to the error handler by status.Errorf
+	errs sync.Map
+}
 
 // Parse populates "values" into "msg".
 // A value is ignored if its key starts with one of the elements in "filter".
-func (*queryParser) Parse(msg proto.Message, values url.Values, filter *utilities.DoubleArray) error {
-	for key, values := range values {
+func (p *queryParser) Parse(msg proto.Message, form url.Values, filter *utilities.DoubleArray) error {
+	for key, values := range form {
 		if match := valuesKeyRegexp.FindStringSubmatch(key); len(match) == 3 {
 			key = match[1]
 			values = append([]string{match[2]}, values...)
@@ -46,17 +54,116 @@ func (*queryParser) Parse(msg proto.Message, values url.Values, filter *utilitie
 			continue
 		}
 		if err := populateFieldValueFromPath(msg.ProtoReflect(), fieldPath, values); err != nil {
+			return p.fail(form, newQueryParamError(key, err))
+		}
+	}
+	if p.pagination != nil {
+		if err := p.pagination.normalizePageFields(msg); err != nil {
+			var qerr *queryParamError
+			if errors.As(err, &qerr) {
+				return p.fail(form, qerr)
+			}
 			return err
 		}
 	}
 	return nil
 }
 
-// PopulateFieldFromPath sets a value in a nested Protobuf structure.
-//func PopulateFieldFromPath(msg proto.Message, fieldPathString string, value string) error {
-//	fieldPath := strings.Split(fieldPathString, ".")
-//	return populateFieldValueFromPath(msg.ProtoReflect(), fieldPath, []string{value})
-//}
+type failedForm struct {
+	form url.Values
+	err  *queryParamError
+}
+
+// fail records the error of form, it's taken by the gateway error handler for localizing
+func (p *queryParser) fail(form url.Values, err *queryParamError) error {
+	if form != nil {
+		p.errs.Store(reflect.ValueOf(form).Pointer(), failedForm{form: form, err: err})
+	}
+	return err
+}
+
+// takeError return the error recorded in parsing form and forget it
+func (p *queryParser) takeError(form url.Values) (*queryParamError, bool) {
+	if form == nil {
+		return nil, false
+	}
+	v, ok := p.errs.LoadAndDelete(reflect.ValueOf(form).Pointer())
+	if !ok {
+		return nil, false
+	}
+	return v.(failedForm).err, true
+}
+
+// queryParamError is the error of parsing query parameter, its message is translated by the Catalog in grpc-gateway error handler
+type queryParamError struct {
+	field string
+	rule  string
+	err   error
+}
+
+func newQueryParamError(field string, err error) *queryParamError {
+	var (
+		rule      = RuleInvalid
+		numErr    *strconv.NumError
+		timeErr   *time.ParseError
+		base64Err base64.CorruptInputError
+	)
+	if errors.As(err, &numErr) || errors.As(err, &timeErr) || errors.As(err, &base64Err) {
+		rule = RuleType
+	}
+	return &queryParamError{field: field, rule: rule, err: err}
+}
+
+// Error implements the error interface, the message of original error is kept
+func (e *queryParamError) Error() string {
+	return e.err.Error()
+}
+
+func (e *queryParamError) Unwrap() error { return e.err }
+
+// PopulateFieldFromPath sets a value in a nested Protobuf structure by the query parser, the message types registered by
+// RegisterQueryParam are supported. Note that the registered types are not applied on the path parameters, since
+// protoc-gen-grpc-gateway only allows the scalar and well-known types in path.
+func PopulateFieldFromPath(msg proto.Message, fieldPathString string, value string) error {
+	fieldPath := strings.Split(fieldPathString, ".")
+	return populateFieldValueFromPath(msg.ProtoReflect(), fieldPath, []string{value})
+}
+
+var (
+	queryParamParsersMu sync.RWMutex
+	queryParamParsers   = map[protoreflect.FullName]func(string) (proto.Message, error){}
+)
+
+// RegisterQueryParam registers the parser of message type named fullName(e.g: "base.Timestamp"), so that the gateway
+// query parameters of the type can be parsed. The well-known types are parsed by the builtin parsers.
+//
+//	apix.RegisterQueryParam("base.Money", func(s string) (proto.Message, error) {
+//		return base.ParseMoney(s)
+//	})
+func RegisterQueryParam(fullName string, parse func(string) (proto.Message, error)) {
+	queryParamParsersMu.Lock()
+	defer queryParamParsersMu.Unlock()
+	queryParamParsers[protoreflect.FullName(fullName)] = parse
+}
+
+// parseRegisteredMessage parses the value by the parser registered by RegisterQueryParam
+func parseRegisteredMessage(msgDescriptor protoreflect.MessageDescriptor, value string) (proto.Message, error) {
+	queryParamParsersMu.RLock()
+	parse, ok := queryParamParsers[msgDescriptor.FullName()]
+	queryParamParsersMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unsupported message type: %q", string(msgDescriptor.FullName()))
+	}
+	msg, err := parse(value)
+	if err != nil {
+		return nil, err
+	}
+	// the typed nil message(e.g: (*base.Money)(nil)) is invalid
+	if msg == nil || !msg.ProtoReflect().IsValid() || msg.ProtoReflect().Descriptor().FullName() != msgDescriptor.FullName() {
+		return nil, fmt.Errorf("query parameter parser of %q returns nil or the wrong message type", string(msgDescriptor.FullName()))
+	}
+	return msg, nil
+}
 
 func populateFieldValueFromPath(msgValue protoreflect.Message, fieldPath []string, values []string) error {
 	if len(fieldPath) < 1 {
@@ -71,15 +178,12 @@ func populateFieldValueFromPath(msgValue protoreflect.Message, fieldPath []strin
 		fields := msgValue.Descriptor().Fields()
 
 		// Get field by name
-		fieldDescriptor = fields.ByName(protoreflect.Name(fieldName))
+		fieldDescriptor = lookupField(fields, fieldName)
 		if fieldDescriptor == nil {
-			fieldDescriptor = fields.ByJSONName(fieldName)
-			if fieldDescriptor == nil {
-				// We're not returning an error here because this could just be
-				// an extra query parameter that isn't part of the request.
-				grpclog.Infof("field not found in %q: %q", msgValue.Descriptor().FullName(), strings.Join(fieldPath, "."))
-				return nil
-			}
+			// We're not returning an error here because this could just be
+			// an extra query parameter that isn't part of the request.
+			grpclog.Infof("field not found in %q: %q", msgValue.Descriptor().FullName(), strings.Join(fieldPath, "."))
+			return nil
 		}
 
 		// If this is the last element, we're done
@@ -117,6 +221,14 @@ func populateFieldValueFromPath(msgValue protoreflect.Message, fieldPath []strin
 	return populateField(fieldDescriptor, msgValue, values[0])
 }
 
+// lookupField find the field by the proto name or json name
+func lookupField(fields protoreflect.FieldDescriptors, name string) protoreflect.FieldDescriptor {
+	if fd := fields.ByName(protoreflect.Name(name)); fd != nil {
+		return fd
+	}
+	return fields.ByJSONName(name)
+}
+
 func populateField(fieldDescriptor protoreflect.FieldDescriptor, msgValue protoreflect.Message, value string) error {
 	v, err := parseField(fieldDescriptor, value)
 	if err != nil {
@@ -347,16 +459,11 @@ func parseMessage(msgDescriptor protoreflect.MessageDescriptor, value string) (p
 			return protoreflect.Value{}, err
 		}
 		msg = &v
-		/*
-			case "base.Timestamp":
-				var v base.Timestamp
-				if err := json.Unmarshal([]byte(value), &v.Seconds); err != nil {
-					return protoreflect.Value{}, err
-				}
-				msg = &v
-		*/
 	default:
-		return protoreflect.Value{}, fmt.Errorf("unsupported message type: %q", string(msgDescriptor.FullName()))
+		var err error
+		if msg, err = parseRegisteredMessage(msgDescriptor, value); err != nil {
+			return protoreflect.Value{}, err
+		}
 	}
 
 	return protoreflect.ValueOfMessage(msg.ProtoReflect()), nil
@@ -0,0 +1,51 @@
+package apix
+
+import (
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/grpc-ecosystem/grpc-gateway/v2/utilities"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+func TestQueryParamError(t *testing.T) {
+	tests := []struct {
+		name    string
+		opts    []ServiceOption
+		want    string
+		wantRaw bool
+	}{
+		{"no catalog", nil, "", true},
+		{"default catalog", []ServiceOption{WithMessageCatalog(DefaultCatalog)}, "seconds has an invalid type", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := New(tt.opts...)
+			parser := &queryParser{}
+			form := url.Values{"seconds": {"ten"}}
+			err := parser.Parse(&durationpb.Duration{}, form, utilities.NewDoubleArray(nil))
+			if err == nil {
+				t.Fatal("expect error")
+			}
+			if strings.Contains(err.Error(), "invalid query parameter") || !strings.Contains(err.Error(), `"ten"`) {
+				t.Errorf("the original message should be kept, got %q", err.Error())
+			}
+			qerr, ok := parser.takeError(form)
+			if !ok || qerr.field != "seconds" || qerr.rule != RuleType {
+				t.Fatalf("got recorded error %#v, %v", qerr, ok)
+			}
+			if _, ok := parser.takeError(form); ok {
+				t.Error("the recorded error should be forgotten after taken")
+			}
+			got := srv.localizeQueryParamError(httptest.NewRequest("GET", "/", nil), qerr)
+			if tt.wantRaw {
+				tt.want = err.Error()
+			}
+			if got != tt.want {
+				t.Errorf("got message %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
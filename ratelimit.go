@@ -0,0 +1,297 @@
+package apix
+
+import (
+	"context"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// RateLimitResult is the decision of RateLimiter
+type RateLimitResult struct {
+	Allowed    bool
+	Limit      int
+	Remaining  int
+	Reset      time.Duration // duration until the quota is fully restored
+	RetryAfter time.Duration // duration the client should wait before retrying, only set when not allowed
+}
+
+// RateLimiter decides whether a request identified by key is allowed
+type RateLimiter interface {
+	Allow(ctx context.Context, key string) (RateLimitResult, error)
+}
+
+// LimiterState is the state of a key persisted in LimiterStore
+type LimiterState struct {
+	// Count is the remaining tokens for token bucket, or the request count in current window for sliding window
+	Count float64
+	// Prev is the request count in previous window, only used by sliding window
+	Prev float64
+	// Stamp is the last refill time for token bucket, or the start time of current window for sliding window
+	Stamp time.Time
+}
+
+// LimiterStore persists the state of rate limiters, implement it on a shared store(eg. redis) so that the limit works across instances.
+// The update function must be applied atomically for the same key, and the state should expire after ttl without updating.
+type LimiterStore interface {
+	Update(ctx context.Context, key string, ttl time.Duration, update func(state *LimiterState)) error
+}
+
+type memoryLimiterEntry struct {
+	state  LimiterState
+	expire time.Time
+}
+
+// memoryLimiterStore is a in-memory LimiterStore
+type memoryLimiterStore struct {
+	mu        sync.Mutex
+	entries   map[string]*memoryLimiterEntry
+	lastSweep time.Time
+}
+
+// NewMemoryLimiterStore create a in-memory LimiterStore, the limit only works in current process
+func NewMemoryLimiterStore() LimiterStore {
+	return &memoryLimiterStore{
+		entries:   make(map[string]*memoryLimiterEntry),
+		lastSweep: time.Now(),
+	}
+}
+
+func (s *memoryLimiterStore) Update(_ context.Context, key string, ttl time.Duration, update func(state *LimiterState)) error {
+	now := time.Now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// remove the expired entries periodically
+	if now.Sub(s.lastSweep) > time.Minute {
+		for k, e := range s.entries {
+			if now.After(e.expire) {
+				delete(s.entries, k)
+			}
+		}
+		s.lastSweep = now
+	}
+
+	e, ok := s.entries[key]
+	if !ok || now.After(e.expire) {
+		e = &memoryLimiterEntry{}
+		s.entries[key] = e
+	}
+	update(&e.state)
+	e.expire = now.Add(ttl)
+	return nil
+}
+
+type tokenBucket struct {
+	rate  float64
+	burst int
+	store LimiterStore
+}
+
+// NewTokenBucket create a token bucket RateLimiter, which refill rate tokens per second with up to burst tokens.
+// The in-memory store will be used if store is nil.
+func NewTokenBucket(rate float64, burst int, store LimiterStore) RateLimiter {
+	if rate <= 0 || math.IsNaN(rate) || math.IsInf(rate, 0) || burst <= 0 {
+		panic("token bucket rate and burst must be positive")
+	}
+	if store == nil {
+		store = NewMemoryLimiterStore()
+	}
+	return &tokenBucket{rate: rate, burst: burst, store: store}
+}
+
+func (tb *tokenBucket) Allow(ctx context.Context, key string) (RateLimitResult, error) {
+	var (
+		now    = time.Now()
+		burst  = float64(tb.burst)
+		result = RateLimitResult{Limit: tb.burst}
+		ttl    = time.Duration(burst/tb.rate*float64(time.Second)) + time.Second
+	)
+	err := tb.store.Update(ctx, key, ttl, func(s *LimiterState) {
+		if s.Stamp.IsZero() {
+			s.Count = burst
+		} else {
+			s.Count = math.Min(burst, s.Count+now.Sub(s.Stamp).Seconds()*tb.rate)
+		}
+		s.Stamp = now
+		if s.Count >= 1 {
+			s.Count--
+			result.Allowed = true
+		} else {
+			result.RetryAfter = time.Duration((1 - s.Count) / tb.rate * float64(time.Second))
+		}
+		result.Remaining = int(s.Count)
+		result.Reset = time.Duration((burst - s.Count) / tb.rate * float64(time.Second))
+	})
+	return result, err
+}
+
+type slidingWindow struct {
+	limit  int
+	window time.Duration
+	store  LimiterStore
+}
+
+// NewSlidingWindow create a sliding window RateLimiter, which allow up to limit requests in any window duration.
+// It estimates the count by weighting the previous window, so only two counters are stored per key.
+// The in-memory store will be used if store is nil.
+func NewSlidingWindow(limit int, window time.Duration, store LimiterStore) RateLimiter {
+	if limit <= 0 || window <= 0 {
+		panic("sliding window limit and window must be positive")
+	}
+	if store == nil {
+		store = NewMemoryLimiterStore()
+	}
+	return &slidingWindow{limit: limit, window: window, store: store}
+}
+
+func (sw *slidingWindow) Allow(ctx context.Context, key string) (RateLimitResult, error) {
+	var (
+		now    = time.Now()
+		start  = now.Truncate(sw.window)
+		limit  = float64(sw.limit)
+		result = RateLimitResult{Limit: sw.limit}
+	)
+	err := sw.store.Update(ctx, key, 2*sw.window, func(s *LimiterState) {
+		if !s.Stamp.Equal(start) {
+			if s.Stamp.Add(sw.window).Equal(start) {
+				s.Prev = s.Count
+			} else {
+				s.Prev = 0
+			}
+			s.Count = 0
+			s.Stamp = start
+		}
+		weight := 1 - float64(now.Sub(start))/float64(sw.window)
+		estimated := s.Prev*weight + s.Count
+		if estimated+1 <= limit {
+			s.Count++
+			estimated++
+			result.Allowed = true
+		} else {
+			result.RetryAfter = start.Add(sw.window).Sub(now)
+		}
+		result.Remaining = int(math.Max(0, math.Floor(limit-estimated)))
+		result.Reset = start.Add(sw.window).Sub(now)
+	})
+	return result, err
+}
+
+// KeyFunc extracts the rate limit key from request, the request won't be limited if it returns empty string
+type KeyFunc func(r *http.Request) string
+
+// KeyByIP use the client ip of the connection as key.
+// Note: the proxy headers like X-Forwarded-For is not trusted, use KeyByHeader if the service is behind a trusted proxy.
+func KeyByIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// KeyByPrincipal use the ID of request principal as key
+func KeyByPrincipal(r *http.Request) string {
+	if p := PrincipalFrom(r.Context()); p != nil {
+		return p.ID
+	}
+	return ""
+}
+
+// KeyByHeader use the value of header as key
+func KeyByHeader(name string) KeyFunc {
+	return func(r *http.Request) string {
+		return r.Header.Get(name)
+	}
+}
+
+// RateLimit create a middleware limiting the request rate by limiter, the requests are grouped by the key extracted by keyFunc.
+// It can be used globally by WithMiddleware, on group by GROUP, or on single route by WithMiddlewares.
+// The RateLimit-Limit, RateLimit-Remaining and RateLimit-Reset headers are set on response, and 429 status is returned with Retry-After header when limited.
+func RateLimit(limiter RateLimiter, keyFunc KeyFunc) Middleware {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			key := keyFunc(r)
+			if key == "" {
+				next(w, r)
+				return
+			}
+			result, err := limiter.Allow(r.Context(), key)
+			if err != nil {
+				// let it go if the store is unavailable
+				log.Ctx(r.Context()).Error().Err(err).Str("key", key).Msg("Checking rate limit error")
+				next(w, r)
+				return
+			}
+			header := w.Header()
+			header.Set("RateLimit-Limit", strconv.Itoa(result.Limit))
+			header.Set("RateLimit-Remaining", strconv.Itoa(result.Remaining))
+			header.Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(result.Reset)))
+			if !result.Allowed {
+				header.Set("Retry-After", strconv.Itoa(ceilSeconds(result.RetryAfter)))
+				ReturnJSON(w, http.StatusTooManyRequests, ResponseBody{Code: http.StatusTooManyRequests, Message: "too many requests"})
+				return
+			}
+			next(w, r)
+		}
+	}
+}
+
+// ConcurrencyLimit create a middleware limiting the number of in-flight requests to max.
+// Up to queue requests wait at most timeout for a free slot(forever until request canceled if timeout <= 0),
+// the others are shed immediately with 503 status.
+func ConcurrencyLimit(max, queue int, timeout time.Duration) Middleware {
+	if max <= 0 {
+		panic("concurrency limit must be positive")
+	}
+	var (
+		slots   = make(chan struct{}, max)
+		waiting atomic.Int64
+	)
+	shed := func(w http.ResponseWriter) {
+		w.Header().Set("Retry-After", "1")
+		ReturnJSON(w, http.StatusServiceUnavailable, ResponseBody{Code: http.StatusServiceUnavailable, Message: "server overloaded"})
+	}
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			select {
+			case slots <- struct{}{}:
+			default:
+				if waiting.Add(1) > int64(queue) {
+					waiting.Add(-1)
+					shed(w)
+					return
+				}
+				var expired <-chan time.Time
+				if timeout > 0 {
+					timer := time.NewTimer(timeout)
+					defer timer.Stop()
+					expired = timer.C
+				}
+				select {
+				case slots <- struct{}{}:
+					waiting.Add(-1)
+				case <-expired:
+					waiting.Add(-1)
+					shed(w)
+					return
+				case <-r.Context().Done():
+					waiting.Add(-1)
+					return
+				}
+			}
+			defer func() { <-slots }()
+			next(w, r)
+		}
+	}
+}
+
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
@@ -0,0 +1,45 @@
+package apix
+
+import (
+	"expvar"
+	"fmt"
+	"net/http"
+	"runtime/debug"
+
+	"github.com/rs/zerolog/log"
+)
+
+var (
+	// panicsTotal counts the recovered panics of all the services, it's published by expvar
+	panicsTotal = expvar.NewInt("apix_panics_total")
+)
+
+// PanicHandler is called after a panic recovered in handler, with the panic value and the stack, e.g: reporting the error to sentry
+type PanicHandler func(r *http.Request, v any, stack []byte)
+
+// WithPanicHandler specifics the hook called on every recovered panic
+func WithPanicHandler(h PanicHandler) ServiceOption {
+	return func(srv *Service) {
+		srv.panicHandler = h
+	}
+}
+
+// handlePanic logs the panic with stack, increase the panic metric and call the panic hook.
+// It returns the error represents the panic.
+func (srv *Service) handlePanic(r *http.Request, v any) error {
+	stack := debug.Stack()
+	panicsTotal.Add(1)
+	log.Ctx(r.Context()).Error().Any("panic", v).Bytes("stack", stack).
+		Str("method", r.Method).Str("path", r.URL.Path).Msg("Recovered from panic")
+	if srv.panicHandler != nil {
+		srv.panicHandler(r, v, stack)
+	}
+	return fmt.Errorf("panic: %v", v)
+}
+
+// failPanic write the 500 response for a recovered panic of grpc-gateway, wrapped by the envelope of service
+func (srv *Service) failPanic(w http.ResponseWriter, r *http.Request, err error) {
+	markFailed(w)
+	status, body := srv.envelope.Error(r, http.StatusInternalServerError, ResponseBody{Code: http.StatusInternalServerError, Message: "internal server error"}, err)
+	ReturnJSON(w, status, body)
+}
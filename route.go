@@ -0,0 +1,110 @@
+package apix
+
+import (
+	"context"
+	"net/http"
+)
+
+// Route describes an api registered on the Service, all the registered routes can be listed by Service.Routes.
+// Apix does not generate OpenAPI documents, the generators can derive the security requirements from Scopes and Roles.
+type Route struct {
+	// Method is the http method of route, it's empty for routes registered by ANY
+	Method string
+	// Pattern is the url path pattern of route
+	Pattern string
+	// Scopes is the scopes required by RequireScopes
+	Scopes []string
+	// Roles is the roles required by RequireRoles
+	Roles []string
+	// Gateway is true for the routes served by grpc-gateway, the Pattern is the gateway path pattern
+	Gateway bool
+	// RPCMethod is the full gRPC method name(e.g: "/pkg.Greeter/SayHello") of the requests served by the gRPC interceptors
+	RPCMethod string
+
+	policies     []Policy
+	middlewares  []Middleware
+	etag         bool
+	weakETag     bool
+	cacheControl string
+	cache        *CacheConfig
+	binder       Binder
+	upload       *UploadConfig
+	envelope     Envelope
+	wildcards    map[string]bool // the wildcard names in pattern
+	internal     bool            // registered by apix itself(e.g: the health probes), the service policies are skipped
+}
+
+// String return the route in "METHOD PATTERN" format, the same as http.ServeMux pattern
+func (r *Route) String() string {
+	if r.Method == "" {
+		return r.Pattern
+	}
+	return r.Method + " " + r.Pattern
+}
+
+// RouteOption customizes a single route, it can be passed on route registering or on a Group by Group.With
+type RouteOption func(*Route)
+
+// WithMiddlewares append middlewares only works on the route
+func WithMiddlewares(middlewares ...Middleware) RouteOption {
+	return func(r *Route) {
+		r.middlewares = append(r.middlewares, middlewares...)
+	}
+}
+
+// RouteFrom return the route of request from the context, it's available in the middlewares of native routes,
+// grpc-gateway routes and gRPC interceptors created by UnaryServerInterceptor and StreamServerInterceptor
+func RouteFrom(ctx context.Context) *Route {
+	route, _ := ctx.Value(routeKey).(*Route)
+	return route
+}
+
+// withRoute wraps the handler with middlewares, the route is put into the request context before middlewares
+func withRoute(h http.HandlerFunc, route *Route, middlewares []Middleware) http.HandlerFunc {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		h = middlewares[i](h)
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		h(w, r.WithContext(context.WithValue(r.Context(), routeKey, route)))
+	}
+}
+
+// Routes return all the routes registered on service, in registering order
+func (srv *Service) Routes() []Route {
+	srv.routesMu.RLock()
+	defer srv.routesMu.RUnlock()
+	routes := make([]Route, 0, len(srv.routes))
+	for _, r := range srv.routes {
+		routes = append(routes, *r)
+	}
+	return routes
+}
+
+func (srv *Service) handle(method, pattern string, h any, middlewares []Middleware, opts []RouteOption) {
+	route := &Route{
+		Method:  method,
+		Pattern: pattern,
+	}
+	for _, opt := range opts {
+		opt(route)
+	}
+	route.wildcards = patternWildcards(route.Pattern)
+	srv.routesMu.Lock()
+	srv.routes = append(srv.routes, route)
+	srv.routesMu.Unlock()
+	srv.mux.Handle(route.String(), srv.generateHandlerFunc(h, route, append(append([]Middleware{}, middlewares...), route.middlewares...)))
+}
+
+func (g *Group) handle(method, pattern string, h any, opts []RouteOption) {
+	g.srv.handle(method, pattern, h, g.middlewares, append(append([]RouteOption{}, g.options...), opts...))
+}
+
+// With create a group with the same url prefix and middlewares, the route options in arguments will be applied on all the routes registered on the new group
+func (g *Group) With(opts ...RouteOption) *Group {
+	return &Group{
+		prefix:      g.prefix,
+		srv:         g.srv,
+		middlewares: g.middlewares,
+		options:     append(append([]RouteOption{}, g.options...), opts...),
+	}
+}
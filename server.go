@@ -0,0 +1,266 @@
+package apix
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"golang.org/x/net/http2"
+)
+
+const systemdListenFdsStart = 3
+
+// Server serves several handlers(usually Services) on several listeners in one process, e.g: the api on a public port,
+// and the metrics, health and debug endpoints on an internal port. All the listeners are shut down together by Shutdown.
+//
+//	server := apix.NewServer()
+//	server.Handle(":8080", api)
+//	server.Handle("unix:/run/app/admin.sock", admin)
+//	go server.ListenAndServe()
+//	...
+//	server.Shutdown(ctx)
+type Server struct {
+	mu       sync.Mutex
+	bindings []*serverBinding
+	started  bool
+	closed   bool
+}
+
+type serverBinding struct {
+	addr     string
+	ln       net.Listener
+	listened bool // ln is opened by ListenAndServe instead of passed by Serve
+	handler  http.Handler
+	server   *http.Server
+}
+
+// NewServer create a empty Server
+func NewServer() *Server {
+	return &Server{}
+}
+
+// Handle serves the handler on the address, the address may be:
+//
+//   - "host:port" tcp address
+//   - "unix:/path/to/socket" unix domain socket, the stale socket file is removed before listening
+//   - "systemd:name" the listener passed by systemd socket activation(LISTEN_FDS), name is the FileDescriptorName
+//     of socket unit or the index of listeners, e.g: "systemd:0"
+func (s *Server) Handle(addr string, h http.Handler) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.bindings = append(s.bindings, &serverBinding{addr: addr, handler: h})
+}
+
+// Serve serves the handler on the pre-opened listener
+func (s *Server) Serve(ln net.Listener, h http.Handler) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.bindings = append(s.bindings, &serverBinding{addr: ln.Addr().String(), ln: ln, handler: h})
+}
+
+// ListenAndServe opens all the listeners and serves them until Shutdown called, it returns http.ErrServerClosed after Shutdown.
+// If any listener failed, all the others are closed and the error is returned.
+func (s *Server) ListenAndServe() error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return http.ErrServerClosed
+	}
+	if s.started {
+		s.mu.Unlock()
+		return errors.New("server already started")
+	}
+	s.started = true
+	bindings := s.bindings
+	for _, b := range bindings {
+		if b.ln == nil {
+			ln, err := Listen(b.addr)
+			if err != nil {
+				s.abortLocked()
+				s.mu.Unlock()
+				return err
+			}
+			b.ln, b.listened = ln, true
+		}
+		b.server = &http.Server{Handler: b.handler}
+		if srv, ok := b.handler.(*Service); ok && srv.h2 != nil {
+			// track the h2c connections, so that they are shut down gracefully with the server
+			if err := http2.ConfigureServer(b.server, srv.h2); err != nil {
+				s.abortLocked()
+				s.mu.Unlock()
+				return err
+			}
+		}
+	}
+	s.mu.Unlock()
+
+	errs := make(chan error, len(bindings))
+	for _, b := range bindings {
+		go func(b *serverBinding) {
+			log.Info().Str("addr", b.addr).Msg("Listening")
+			errs <- b.server.Serve(b.ln)
+		}(b)
+	}
+	var first error
+	for range bindings {
+		err := <-errs
+		if first == nil && err != http.ErrServerClosed {
+			first = err
+			// stop the others if any listener failed
+			for _, b := range bindings {
+				b.server.Close()
+			}
+		}
+	}
+	if first != nil {
+		return first
+	}
+	return http.ErrServerClosed
+}
+
+// abortLocked closes all the listeners(including the pre-opened ones) after ListenAndServe failed, and resets the state
+// of server, the addresses are listened again if ListenAndServe is called again.
+func (s *Server) abortLocked() {
+	for _, b := range s.bindings {
+		if b.ln != nil {
+			b.ln.Close()
+		}
+		if b.listened {
+			b.ln, b.listened = nil, false
+		}
+		b.server = nil
+	}
+	s.started = false
+}
+
+// Shutdown gracefully shuts down all the listeners. The readiness of hosted Services are flipped to failing at first,
+// and the listeners are shut down together after the longest shutdown delay of Services, then the gRPC servers of Services.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.mu.Lock()
+	s.closed = true
+	bindings := s.bindings
+	var servers []*serverBinding
+	for _, b := range bindings {
+		if b.server != nil {
+			servers = append(servers, b)
+		}
+	}
+	s.mu.Unlock()
+
+	var delay time.Duration
+	for _, b := range bindings {
+		if srv, ok := b.handler.(*Service); ok {
+			srv.health.draining.Store(true)
+			delay = max(delay, srv.shutdownDelay)
+		}
+	}
+	if delay > 0 {
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	var (
+		wg   sync.WaitGroup
+		mu   sync.Mutex
+		errs []error
+	)
+	for _, b := range servers {
+		wg.Add(1)
+		go func(b *serverBinding) {
+			defer wg.Done()
+			if err := b.server.Shutdown(ctx); err != nil {
+				mu.Lock()
+				errs = append(errs, fmt.Errorf("shutdown %s: %w", b.addr, err))
+				mu.Unlock()
+			}
+		}(b)
+	}
+	wg.Wait()
+	// the gRPC servers are stopped after the http requests finished, since the grpc-gateway may call them in process
+	for _, b := range bindings {
+		if srv, ok := b.handler.(*Service); ok {
+			if err := srv.stopGRPC(ctx); err != nil {
+				errs = append(errs, fmt.Errorf("stop grpc server of %s: %w", b.addr, err))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Listen create a listener on the address, see Server.Handle for the address formats
+func Listen(addr string) (net.Listener, error) {
+	if p, ok := strings.CutPrefix(addr, "unix:"); ok {
+		if err := os.Remove(p); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+		return net.Listen("unix", p)
+	}
+	if name, ok := strings.CutPrefix(addr, "systemd:"); ok {
+		return systemdListener(name)
+	}
+	return net.Listen("tcp", addr)
+}
+
+var (
+	systemdOnce      sync.Once
+	systemdListeners []net.Listener
+	systemdNames     []string
+	systemdErr       error
+)
+
+// systemdListener return the listener passed by systemd with the name or index
+func systemdListener(name string) (net.Listener, error) {
+	systemdOnce.Do(func() {
+		systemdListeners, systemdNames, systemdErr = loadSystemdListeners()
+	})
+	if systemdErr != nil {
+		return nil, systemdErr
+	}
+	for i, n := range systemdNames {
+		if n == name {
+			return systemdListeners[i], nil
+		}
+	}
+	if i, err := strconv.Atoi(name); err == nil && i >= 0 && i < len(systemdListeners) {
+		return systemdListeners[i], nil
+	}
+	return nil, fmt.Errorf("systemd listener %q not found", name)
+}
+
+// loadSystemdListeners load the listeners by the LISTEN_PID, LISTEN_FDS and LISTEN_FDNAMES environments, see sd_listen_fds(3)
+func loadSystemdListeners() ([]net.Listener, []string, error) {
+	pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	if err != nil || pid != os.Getpid() {
+		return nil, nil, errors.New("no listeners passed by systemd")
+	}
+	n, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || n <= 0 {
+		return nil, nil, errors.New("no listeners passed by systemd")
+	}
+	names := strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+	listeners := make([]net.Listener, 0, n)
+	for i := 0; i < n; i++ {
+		f := os.NewFile(uintptr(systemdListenFdsStart+i), "LISTEN_FD_"+strconv.Itoa(systemdListenFdsStart+i))
+		ln, err := net.FileListener(f)
+		f.Close()
+		if err != nil {
+			return nil, nil, fmt.Errorf("systemd listener %d: %w", i, err)
+		}
+		listeners = append(listeners, ln)
+	}
+	if len(names) != n {
+		names = nil
+	}
+	return listeners, names, nil
+}
@@ -0,0 +1,39 @@
+package apix
+
+import (
+	"net"
+	"net/http"
+	"testing"
+)
+
+func TestServerListenFailure(t *testing.T) {
+	busy, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer busy.Close()
+	first, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	last, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := NewServer()
+	s.Serve(first, http.NotFoundHandler())
+	s.Handle(busy.Addr().String(), http.NotFoundHandler())
+	s.Serve(last, http.NotFoundHandler())
+	if err := s.ListenAndServe(); err == nil {
+		t.Fatal("expect the error of listening on the busy address")
+	}
+	for _, ln := range []net.Listener{first, last} {
+		if _, err := ln.Accept(); err == nil {
+			t.Errorf("the listener %s should be closed", ln.Addr())
+		}
+	}
+	if s.started {
+		t.Error("the server should not be started after failure")
+	}
+}
@@ -1,73 +1,132 @@
 package apix
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
+	"mime/multipart"
 	"net/http"
 	"path"
 	"reflect"
+	"strings"
+	"sync"
 	"time"
 
-	"github.com/bytedance/go-tagexpr/v2/binding"
 	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
 	"github.com/rs/zerolog/log"
+	"golang.org/x/net/http2"
+	"google.golang.org/grpc"
 )
 
 type Service struct {
-	mux                *http.ServeMux
-	grpc               *grpcHandler
-	grpcHeaderPatterns []string
-	notFoundHandler    http.Handler
-	middlewares        []Middleware
-	marshaler          func(data any) ([]byte, error)
+	mux                  *http.ServeMux
+	grpc                 *grpcHandler
+	grpcHeaderPatterns   []string
+	notFoundHandler      http.Handler
+	middlewares          []Middleware
+	marshaler            func(data any) ([]byte, error)
+	policies             []Policy
+	roleScopes           map[string][]string
+	routesMu             sync.RWMutex
+	routes               []*Route
+	panicHandler         PanicHandler
+	compression          *CompressionConfig
+	handler              http.HandlerFunc
+	cacheStore           ResponseCacheStore
+	cacheFlightsMu       sync.Mutex
+	cacheFlights         map[string]*cacheFlight
+	catalog              *Catalog
+	binder               Binder
+	bindHooks            []BindHook
+	health               *healthRegistry
+	healthEndpoints      bool
+	shutdownDelay        time.Duration
+	serverMu             sync.Mutex
+	server               *Server
+	debug                *DebugOptions
+	adminHandler         http.Handler
+	grpcServer           *grpc.Server
+	h2                   *http2.Server
+	grpcMu               sync.Mutex
+	grpcCalls            sync.WaitGroup
+	grpcStopping         bool
+	gatewayOptions       []runtime.ServeMuxOption
+	metadataForwarding   *MetadataForwarding
+	envelope             Envelope
+	pagination           PaginationConfig
+	partialResponseParam string
+	grpcInProcessOnly    bool
+	inProcessOnce        sync.Once
+	inProcessConn        *grpc.ClientConn
+	inProcessErr         error
 }
 
-const (
-	rpcResponseWrapPrefix = `{"code":0,"data":`
-	rpcResponseWrapSuffix = `}`
-)
-
 func New(opts ...ServiceOption) *Service {
 	srv := &Service{
-		marshaler: json.Marshal,
-		mux:       http.NewServeMux(),
+		marshaler:    json.Marshal,
+		mux:          http.NewServeMux(),
+		cacheFlights: make(map[string]*cacheFlight),
+		health:       &healthRegistry{},
 	}
 	for _, opt := range opts {
 		opt(srv)
 	}
+	if srv.binder == nil {
+		srv.binder = NewTagExprBinder(nil)
+	}
+	if srv.cacheStore == nil {
+		srv.cacheStore = NewMemoryCacheStore(0, 0)
+	}
+	if srv.envelope == nil {
+		srv.envelope = DefaultEnvelope
+	}
+	srv.pagination.normalize()
 	srv.grpc = newGRPCHandler(srv)
+	if srv.healthEndpoints {
+		srv.registerHealthEndpoints()
+	}
+	if srv.debug != nil {
+		srv.registerDebugEndpoints()
+	}
+	srv.handler = srv.serve
+	if srv.compression != nil {
+		srv.handler = Compress(*srv.compression)(srv.handler)
+	}
+	if srv.grpcServer != nil && !srv.grpcInProcessOnly {
+		srv.handler = srv.serveGRPC(srv.handler)
+	}
 	return srv
 }
 
-func (srv *Service) ANY(path string, h any) {
-	srv.mux.Handle(path, srv.generateHandlerFunc(h, srv.middlewares))
+func (srv *Service) ANY(path string, h any, opts ...RouteOption) {
+	srv.handle("", path, h, srv.middlewares, opts)
 }
-func (srv *Service) GET(path string, h any) {
-	srv.mux.Handle("GET "+path, srv.generateHandlerFunc(h, srv.middlewares))
+func (srv *Service) GET(path string, h any, opts ...RouteOption) {
+	srv.handle("GET", path, h, srv.middlewares, opts)
 }
-func (srv *Service) POST(path string, h any) {
-	srv.mux.Handle("POST "+path, srv.generateHandlerFunc(h, srv.middlewares))
+func (srv *Service) POST(path string, h any, opts ...RouteOption) {
+	srv.handle("POST", path, h, srv.middlewares, opts)
 }
-func (srv *Service) PUT(path string, h any) {
-	srv.mux.Handle("PUT "+path, srv.generateHandlerFunc(h, srv.middlewares))
+func (srv *Service) PUT(path string, h any, opts ...RouteOption) {
+	srv.handle("PUT", path, h, srv.middlewares, opts)
 }
-func (srv *Service) PATCH(path string, h any) {
-	srv.mux.Handle("PATCH "+path, srv.generateHandlerFunc(h, srv.middlewares))
+func (srv *Service) PATCH(path string, h any, opts ...RouteOption) {
+	srv.handle("PATCH", path, h, srv.middlewares, opts)
 }
-func (srv *Service) DELETE(path string, h any) {
-	srv.mux.Handle("DELETE "+path, srv.generateHandlerFunc(h, srv.middlewares))
+func (srv *Service) DELETE(path string, h any, opts ...RouteOption) {
+	srv.handle("DELETE", path, h, srv.middlewares, opts)
 }
-func (srv *Service) TRACE(path string, h any) {
-	srv.mux.Handle("TRACE "+path, srv.generateHandlerFunc(h, srv.middlewares))
+func (srv *Service) TRACE(path string, h any, opts ...RouteOption) {
+	srv.handle("TRACE", path, h, srv.middlewares, opts)
 }
-func (srv *Service) HEAD(path string, h any) {
-	srv.mux.Handle("HEAD "+path, srv.generateHandlerFunc(h, srv.middlewares))
+func (srv *Service) HEAD(path string, h any, opts ...RouteOption) {
+	srv.handle("HEAD", path, h, srv.middlewares, opts)
 }
-func (srv *Service) OPTION(path string, h any) {
-	srv.mux.Handle("OPTION "+path, srv.generateHandlerFunc(h, srv.middlewares))
+func (srv *Service) OPTION(path string, h any, opts ...RouteOption) {
+	srv.handle("OPTION", path, h, srv.middlewares, opts)
 }
-func (srv *Service) CONNECT(path string, h any) {
-	srv.mux.Handle("CONNECT "+path, srv.generateHandlerFunc(h, srv.middlewares))
+func (srv *Service) CONNECT(path string, h any, opts ...RouteOption) {
+	srv.handle("CONNECT", path, h, srv.middlewares, opts)
 }
 
 // GROUP create a api group with custom url prefix and middlewares, the middlewares only works on handlers registerd on this group
@@ -86,6 +145,10 @@ func (srv *Service) GRPCGatewayMux() *runtime.ServeMux {
 
 // ServeHTTP implements the http.Handler interface
 func (srv *Service) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	srv.handler(w, req)
+}
+
+func (srv *Service) serve(w http.ResponseWriter, req *http.Request) {
 	// hijack not found status to grpc gateway handler
 	notFoundHijack := statusHijack{
 		targetCode:     http.StatusNotFound,
@@ -96,16 +159,41 @@ func (srv *Service) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 	srv.mux.ServeHTTP(&notFoundHijack, req)
 }
 
-func (srv *Service) newCtx(w http.ResponseWriter, r *http.Request) *Context {
+func (srv *Service) newCtx(w http.ResponseWriter, r *http.Request, route *Route) *Context {
 	return &Context{
 		Request: r,
 		Writer:  w,
 		srv:     srv,
+		route:   route,
 	}
 }
 
+// ListenAndServe serves the service on addr, which may be a tcp address, unix socket or systemd socket, see Server.Handle.
+// The admin listener of debug endpoints is also started if configured. Use Server to serve multiple Services.
 func (srv *Service) ListenAndServe(addr string) error {
-	return http.ListenAndServe(addr, srv)
+	server := NewServer()
+	server.Handle(addr, srv)
+	if srv.adminHandler != nil {
+		server.Handle(srv.debug.Addr, srv.adminHandler)
+	}
+	srv.serverMu.Lock()
+	srv.server = server
+	srv.serverMu.Unlock()
+	return server.ListenAndServe()
+}
+
+// Shutdown gracefully shuts down the service started by ListenAndServe. The readiness is flipped to failing at first,
+// and the server is shut down after the shutdown delay, so that the load balancers can stop routing requests in time.
+// The gRPC server specified by WithGRPCServer is gracefully stopped after the http requests finished.
+func (srv *Service) Shutdown(ctx context.Context) error {
+	srv.serverMu.Lock()
+	server := srv.server
+	srv.serverMu.Unlock()
+	if server == nil {
+		srv.health.draining.Store(true)
+		return nil
+	}
+	return server.Shutdown(ctx)
 }
 
 type ServiceOption func(*Service)
@@ -143,37 +231,38 @@ type Group struct {
 	srv         *Service
 	prefix      string
 	middlewares []Middleware
+	options     []RouteOption
 }
 
-func (g *Group) ANY(p string, h any) {
-	g.srv.mux.Handle(path.Join(g.prefix, p), g.srv.generateHandlerFunc(h, g.middlewares))
+func (g *Group) ANY(p string, h any, opts ...RouteOption) {
+	g.handle("", path.Join(g.prefix, p), h, opts)
 }
-func (g *Group) GET(p string, h any) {
-	g.srv.mux.Handle("GET "+path.Join(g.prefix, p), g.srv.generateHandlerFunc(h, g.middlewares))
+func (g *Group) GET(p string, h any, opts ...RouteOption) {
+	g.handle("GET", path.Join(g.prefix, p), h, opts)
 }
-func (g *Group) POST(p string, h any) {
-	g.srv.mux.Handle("POST "+path.Join(g.prefix, p), g.srv.generateHandlerFunc(h, g.middlewares))
+func (g *Group) POST(p string, h any, opts ...RouteOption) {
+	g.handle("POST", path.Join(g.prefix, p), h, opts)
 }
-func (g *Group) PUT(p string, h any) {
-	g.srv.mux.Handle("PUT "+path.Join(g.prefix, p), g.srv.generateHandlerFunc(h, g.middlewares))
+func (g *Group) PUT(p string, h any, opts ...RouteOption) {
+	g.handle("PUT", path.Join(g.prefix, p), h, opts)
 }
-func (g *Group) PATCH(p string, h any) {
-	g.srv.mux.Handle("PATCH "+path.Join(g.prefix, p), g.srv.generateHandlerFunc(h, g.middlewares))
+func (g *Group) PATCH(p string, h any, opts ...RouteOption) {
+	g.handle("PATCH", path.Join(g.prefix, p), h, opts)
 }
-func (g *Group) DELETE(p string, h any) {
-	g.srv.mux.Handle("DELETE "+path.Join(g.prefix, p), g.srv.generateHandlerFunc(h, g.middlewares))
+func (g *Group) DELETE(p string, h any, opts ...RouteOption) {
+	g.handle("DELETE", path.Join(g.prefix, p), h, opts)
 }
-func (g *Group) TRACE(p string, h any) {
-	g.srv.mux.Handle("TRACE "+path.Join(g.prefix, p), g.srv.generateHandlerFunc(h, g.middlewares))
+func (g *Group) TRACE(p string, h any, opts ...RouteOption) {
+	g.handle("TRACE", path.Join(g.prefix, p), h, opts)
 }
-func (g *Group) HEAD(p string, h any) {
-	g.srv.mux.Handle("HEAD "+path.Join(g.prefix, p), g.srv.generateHandlerFunc(h, g.middlewares))
+func (g *Group) HEAD(p string, h any, opts ...RouteOption) {
+	g.handle("HEAD", path.Join(g.prefix, p), h, opts)
 }
-func (g *Group) OPTION(p string, h any) {
-	g.srv.mux.Handle("OPTION "+path.Join(g.prefix, p), g.srv.generateHandlerFunc(h, g.middlewares))
+func (g *Group) OPTION(p string, h any, opts ...RouteOption) {
+	g.handle("OPTION", path.Join(g.prefix, p), h, opts)
 }
-func (g *Group) CONNECT(p string, h any) {
-	g.srv.mux.Handle("CONNECT "+p, g.srv.generateHandlerFunc(h, g.middlewares))
+func (g *Group) CONNECT(p string, h any, opts ...RouteOption) {
+	g.handle("CONNECT", p, h, opts)
 }
 
 // GROUP create a sub group base on this group. The url path and middlewares in arguments will append to the parent group's path and middlewares
@@ -182,10 +271,11 @@ func (g *Group) GROUP(p string, middlewares ...Middleware) *Group {
 		prefix:      path.Join(g.prefix, p),
 		srv:         g.srv,
 		middlewares: append(append([]Middleware{}, g.middlewares...), middlewares...),
+		options:     g.options,
 	}
 }
 
-func (srv *Service) generateHandlerFunc(handler any, middlewares []Middleware) http.HandlerFunc {
+func (srv *Service) generateHandlerFunc(handler any, route *Route, middlewares []Middleware) http.HandlerFunc {
 	htype := 0
 	switch h := handler.(type) {
 	case Handler:
@@ -212,7 +302,7 @@ func (srv *Service) generateHandlerFunc(handler any, middlewares []Middleware) h
 		var (
 			start  = time.Now()
 			err    error
-			ctx    = srv.newCtx(w, r)
+			ctx    = srv.newCtx(w, r, route)
 			v      = reflect.New(t).Interface()
 			data   any
 			status = 0
@@ -224,13 +314,58 @@ func (srv *Service) generateHandlerFunc(handler any, middlewares []Middleware) h
 				Str("method", r.Method).Str("path", r.URL.Path).Msg("HTTP request")
 		}()
 
+		// recover the panic in handler, so that the client get a 500 response and the access log is written
+		defer func() {
+			if p := recover(); p != nil {
+				if p == http.ErrAbortHandler {
+					panic(p)
+				}
+				err = srv.handlePanic(r, p)
+				status = http.StatusInternalServerError
+				if !ctx.returned {
+					ctx.returnError(status, ResponseBody{Code: status, Message: "internal server error"}, err)
+				}
+			}
+		}()
+
+		// check the permission before binding
+		if err = srv.authorize(ctx, route); err != nil {
+			status = err.(ResponseBody).Code
+			ctx.returnError(status, err.(ResponseBody), err)
+			return
+		}
+
+		// serve the response from cache, or record the response into cache
+		if route.cache != nil {
+			rc, served := srv.serveCache(ctx, route)
+			if served {
+				return
+			}
+			if rc != nil {
+				defer func() { rc.finish(ctx, err != nil) }()
+			}
+		}
+
 		if htype == 1 || htype == 2 {
+			// parse the multipart form with size limits, the temp files are removed after request
+			if route.upload != nil {
+				var form *multipart.Form
+				if form, err = parseMultipartForm(ctx, route.upload); err != nil {
+					status = err.(*uploadError).status
+					ctx.returnError(status, ResponseBody{Code: status, Message: err.Error()}, err)
+					return
+				}
+				if form != nil {
+					defer form.RemoveAll()
+				}
+			}
+
 			// parse the parameters from request
-			if err = binding.New(nil).BindAndValidate(v, r, pathParams{req: r}); err != nil {
-				ctx.ReturnJSON(400, ResponseBody{
+			if err = srv.bind(ctx, route, v); err != nil {
+				ctx.returnError(400, ResponseBody{
 					Code:    400,
-					Message: err.Error(),
-				})
+					Message: srv.localizeBindingError(r, v, err),
+				}, err)
 				return
 			}
 		}
@@ -256,37 +391,31 @@ func (srv *Service) generateHandlerFunc(handler any, middlewares []Middleware) h
 			if status == 0 {
 				status = 1
 			}
-			ctx.ReturnJSON(200, ResponseBody{
+			ctx.returnError(200, ResponseBody{
 				Code:    status,
 				Message: err.Error(),
-			})
+			}, err)
 			return
 		}
 
 		// response data
 		if data != nil {
-			if _, ok := data.(ResponseBody); ok {
-				// data's type is ResponseBody, response directly
-				ctx.ReturnJSON(200, data)
-			} else {
-				value := reflect.ValueOf(data)
-				if value.Kind() == reflect.Slice && value.Len() == 0 {
-					// return empty array instread of null for nil slice
-					data = []struct{}{}
-				}
-				ctx.ReturnJSON(200, ResponseBody{Code: 0, Data: data})
+			value := reflect.ValueOf(data)
+			if value.Kind() == reflect.Slice && value.Len() == 0 {
+				// return empty array instread of null for nil slice
+				data = []struct{}{}
 			}
+			ctx.returnData(data)
 			return
 		}
 
-		// response nothing
-		w.WriteHeader(http.StatusNoContent)
+		// response nothing, unless the handler has already responsed(e.g: precondition failed)
+		if !ctx.returned {
+			ctx.Writer.WriteHeader(http.StatusNoContent)
+		}
 	}
 
-	for i := len(middlewares) - 1; i >= 0; i-- {
-		h = middlewares[i](h)
-	}
-	return h
+	return withRoute(h, route, middlewares)
 }
 
 // Middleware wrap the http.HandlerFunc, so that it can handle the http.Request in advance and intercept the request if required(eg. authorization, logging)
@@ -319,13 +448,33 @@ type HandlerCode interface {
 
 // pathParams implements the binding.PathParams interface for http.Request, so that the binding.BindAndValidate can parse the parameters in the request path.
 type pathParams struct {
-	req *http.Request
+	req       *http.Request
+	wildcards map[string]bool
 }
 
-// Get the parameter in url path
-//
-// Note: the second return value always be true, it mainly used to satisfy the binding.PathParams interface
+// Get the parameter in url path, it's found only if name is a wildcard of the route pattern
 func (pp pathParams) Get(name string) (string, bool) {
-	value := pp.req.PathValue(name)
-	return value, true
+	if !pp.wildcards[name] {
+		return "", false
+	}
+	return pp.req.PathValue(name), true
+}
+
+// patternWildcards return the wildcard names in pattern, e.g: "id" and "path" for "GET /users/{id}/files/{path...}"
+func patternWildcards(pattern string) map[string]bool {
+	wildcards := make(map[string]bool)
+	for {
+		start := strings.IndexByte(pattern, '{')
+		if start < 0 {
+			return wildcards
+		}
+		end := strings.IndexByte(pattern[start:], '}')
+		if end < 0 {
+			return wildcards
+		}
+		if name := strings.TrimSuffix(pattern[start+1:start+end], "..."); name != "$" {
+			wildcards[name] = true
+		}
+		pattern = pattern[start+end+1:]
+	}
 }
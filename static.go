@@ -0,0 +1,173 @@
+package apix
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/fs"
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+// StaticOptions configures the static files serving
+type StaticOptions struct {
+	// Index is the index file of directories, default "index.html"
+	Index string
+	// SPA serves the index file of root for the unknown paths requested by browser(Accept: text/html), so that the
+	// single page application can handle its routes. The other requests on unknown paths still fall through to grpc-gateway.
+	SPA bool
+	// Precompressed serves the "<file>.br" or "<file>.gz" variant if it exists and accepted by client
+	Precompressed bool
+	// CacheControl is the Cache-Control header of files, e.g: "public, max-age=3600"
+	CacheControl string
+	// IndexCacheControl is the Cache-Control header of index files, default "no-cache", so that the new version of SPA is loaded in time
+	IndexCacheControl string
+}
+
+// Static serves the files in fsys(e.g: embed.FS) under the url prefix, with ETag, Last-Modified and range requests supported.
+//
+//	//go:embed dist
+//	var dist embed.FS
+//	sub, _ := fs.Sub(dist, "dist")
+//	srv.Static("/admin/", sub, apix.StaticOptions{SPA: true})
+func (srv *Service) Static(prefix string, fsys fs.FS, opts StaticOptions, routeOpts ...RouteOption) {
+	srv.handle("GET", staticPattern(prefix), newStaticHandler(fsys, opts), srv.middlewares, routeOpts)
+}
+
+// Static serves the files in fsys under the url prefix of group
+func (g *Group) Static(p string, fsys fs.FS, opts StaticOptions, routeOpts ...RouteOption) {
+	g.handle("GET", staticPattern(path.Join(g.prefix, p)), newStaticHandler(fsys, opts), routeOpts)
+}
+
+func staticPattern(prefix string) string {
+	if !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+	return prefix + "{path...}"
+}
+
+type staticHandler struct {
+	fsys  fs.FS
+	opts  StaticOptions
+	etags sync.Map // the etags of files, keyed by name and modified time
+}
+
+func newStaticHandler(fsys fs.FS, opts StaticOptions) *staticHandler {
+	if opts.Index == "" {
+		opts.Index = "index.html"
+	}
+	if opts.IndexCacheControl == "" {
+		opts.IndexCacheControl = "no-cache"
+	}
+	return &staticHandler{fsys: fsys, opts: opts}
+}
+
+func (sh *staticHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(path.Clean("/"+r.PathValue("path")), "/")
+	if name == "" {
+		name = "."
+	}
+
+	fi, err := fs.Stat(sh.fsys, name)
+	if err == nil && fi.IsDir() {
+		name = path.Join(name, sh.opts.Index)
+		fi, err = fs.Stat(sh.fsys, name)
+	}
+	if err != nil {
+		if !errors.Is(err, fs.ErrNotExist) {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if !sh.opts.SPA || !strings.Contains(r.Header.Get("Accept"), "text/html") {
+			// the 404 response will be hijacked to grpc-gateway
+			http.NotFound(w, r)
+			return
+		}
+		name = sh.opts.Index
+		if fi, err = fs.Stat(sh.fsys, name); err != nil {
+			http.NotFound(w, r)
+			return
+		}
+	}
+
+	header := w.Header()
+	if path.Base(name) == sh.opts.Index {
+		header.Set("Cache-Control", sh.opts.IndexCacheControl)
+	} else if sh.opts.CacheControl != "" {
+		header.Set("Cache-Control", sh.opts.CacheControl)
+	}
+	if ctype := mime.TypeByExtension(path.Ext(name)); ctype != "" {
+		header.Set("Content-Type", ctype)
+	}
+
+	// serve the precompressed variant
+	file := name
+	if sh.opts.Precompressed {
+		header.Add("Vary", "Accept-Encoding")
+		encoding := negotiateEncoding(r.Header.Get("Accept-Encoding"), []string{compressionEncodingBrotli, compressionEncodingGzip})
+		ext := map[string]string{compressionEncodingBrotli: ".br", compressionEncodingGzip: ".gz"}[encoding]
+		if ext != "" {
+			if cfi, err := fs.Stat(sh.fsys, name+ext); err == nil && !cfi.IsDir() {
+				file, fi = name+ext, cfi
+				header.Set("Content-Encoding", encoding)
+			}
+		}
+	}
+
+	content, err := sh.open(file)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if c, ok := content.(io.Closer); ok {
+		defer c.Close()
+	}
+	etag, err := sh.etag(file, fi, content)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	header.Set("ETag", etag)
+	http.ServeContent(w, r, name, fi.ModTime(), content)
+}
+
+// open the file as io.ReadSeeker, the file content is read into memory if it's not seekable
+func (sh *staticHandler) open(name string) (io.ReadSeeker, error) {
+	f, err := sh.fsys.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	if rs, ok := f.(io.ReadSeeker); ok {
+		return rs, nil
+	}
+	defer f.Close()
+	data, err := io.ReadAll(f)
+	if err != nil {
+		return nil, err
+	}
+	return bytes.NewReader(data), nil
+}
+
+// etag return the strong etag by the file content hash, it's cached by the name and modified time
+func (sh *staticHandler) etag(name string, fi fs.FileInfo, content io.ReadSeeker) (string, error) {
+	key := name + "@" + fi.ModTime().Format(time.RFC3339Nano)
+	if etag, ok := sh.etags.Load(key); ok {
+		return etag.(string), nil
+	}
+	h := sha256.New()
+	if _, err := io.Copy(h, content); err != nil {
+		return "", err
+	}
+	if _, err := content.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	etag := `"` + hex.EncodeToString(h.Sum(nil)[:16]) + `"`
+	sh.etags.Store(key, etag)
+	return etag, nil
+}
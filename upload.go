@@ -0,0 +1,181 @@
+package apix
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"net/url"
+)
+
+const (
+	defaultUploadMaxFileSize  = 32 << 20
+	defaultUploadMaxTotalSize = 64 << 20
+	defaultUploadMaxMemory    = 1 << 20
+	sniffLen                  = 512
+)
+
+// UploadConfig configures the multipart form parsing of a route
+type UploadConfig struct {
+	// MaxFileSize limits the size of each uploaded file, default 32MB
+	MaxFileSize int64
+	// MaxTotalSize limits the size of whole request body, default 64MB
+	MaxTotalSize int64
+	// MaxMemory is the threshold of keeping the form in memory, the files exceeding it are spilled to temp files on disk, default 1MB
+	MaxMemory int64
+	// AllowedTypes is the allow-list of file content types sniffed from the content, wildcard pattern supported(e.g. "image/*").
+	// All the types are allowed if it's empty.
+	AllowedTypes []string
+}
+
+// WithUpload parses the multipart form of the route's requests by cfg before binding, so that the files can be bound into
+// the *multipart.FileHeader or []*multipart.FileHeader fields with form tag. The form is parsed in streaming, the requests
+// exceeding size limits are rejected by 413 status without reading the whole body, and the temp files are removed after request.
+//
+//	type Upload struct {
+//		Avatar *multipart.FileHeader   `form:"avatar,required"`
+//		Photos []*multipart.FileHeader `form:"photos"`
+//	}
+func WithUpload(cfg UploadConfig) RouteOption {
+	if cfg.MaxFileSize <= 0 {
+		cfg.MaxFileSize = defaultUploadMaxFileSize
+	}
+	if cfg.MaxTotalSize <= 0 {
+		cfg.MaxTotalSize = defaultUploadMaxTotalSize
+	}
+	if cfg.MaxMemory <= 0 {
+		cfg.MaxMemory = defaultUploadMaxMemory
+	}
+	return func(r *Route) {
+		r.upload = &cfg
+	}
+}
+
+// uploadError is the error of parsing multipart form, with the status code should be responsed
+type uploadError struct {
+	status int
+	msg    string
+}
+
+func (e *uploadError) Error() string { return e.msg }
+
+// parseMultipartForm parse the multipart form of request by cfg, and set it into Request.MultipartForm.
+// It does nothing if the request is not multipart.
+func parseMultipartForm(ctx *Context, cfg *UploadConfig) (*multipart.Form, error) {
+	r := ctx.Request
+	mediaType, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/form-data" || r.MultipartForm != nil {
+		return nil, nil
+	}
+	boundary := params["boundary"]
+	if boundary == "" {
+		return nil, &uploadError{status: http.StatusBadRequest, msg: "multipart boundary not found"}
+	}
+
+	// validate the parts while streaming them into multipart.Reader.ReadForm, which stores the large files into temp files
+	var (
+		src    = multipart.NewReader(http.MaxBytesReader(ctx.Writer, r.Body, cfg.MaxTotalSize), boundary)
+		pr, pw = io.Pipe()
+		dst    = multipart.NewWriter(pw)
+	)
+	go func() {
+		pw.CloseWithError(copyMultipart(src, dst, cfg))
+	}()
+	form, err := multipart.NewReader(pr, dst.Boundary()).ReadForm(cfg.MaxMemory)
+	pr.CloseWithError(io.ErrClosedPipe) // stop the copying if ReadForm failed
+	if err != nil {
+		var (
+			ue       *uploadError
+			maxBytes *http.MaxBytesError
+		)
+		if errors.As(err, &ue) {
+			return nil, ue
+		}
+		if errors.As(err, &maxBytes) {
+			return nil, &uploadError{status: http.StatusRequestEntityTooLarge, msg: fmt.Sprintf("request body exceeds %d bytes", maxBytes.Limit)}
+		}
+		return nil, &uploadError{status: http.StatusBadRequest, msg: err.Error()}
+	}
+
+	r.MultipartForm = form
+	r.PostForm = url.Values(form.Value)
+	r.Form = r.URL.Query()
+	for k, v := range form.Value {
+		r.Form[k] = append(r.Form[k], v...)
+	}
+	return form, nil
+}
+
+// copyMultipart copy the parts from src to dst, with the file size limited and content type sniffed
+func copyMultipart(src *multipart.Reader, dst *multipart.Writer, cfg *UploadConfig) error {
+	for {
+		part, err := src.NextPart()
+		if err == io.EOF {
+			return dst.Close()
+		}
+		if err != nil {
+			return err
+		}
+		header := textproto.MIMEHeader{}
+		for k, v := range part.Header {
+			header[k] = v
+		}
+		if part.FileName() == "" {
+			w, err := dst.CreatePart(header)
+			if err != nil {
+				return err
+			}
+			if _, err := io.Copy(w, part); err != nil {
+				return err
+			}
+			continue
+		}
+
+		// sniff the content type by the leading bytes
+		head := make([]byte, sniffLen)
+		n, err := io.ReadFull(part, head)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return err
+		}
+		head = head[:n]
+		contentType := http.DetectContentType(head)
+		if !allowedUploadType(cfg.AllowedTypes, contentType) {
+			return &uploadError{status: http.StatusUnsupportedMediaType, msg: fmt.Sprintf("file %q type %s is not allowed", part.FileName(), contentType)}
+		}
+		header.Set("Content-Type", contentType)
+
+		w, err := dst.CreatePart(header)
+		if err != nil {
+			return err
+		}
+		if _, err := w.Write(head); err != nil {
+			return err
+		}
+		written, err := io.Copy(w, io.LimitReader(part, cfg.MaxFileSize-int64(n)+1))
+		if err != nil {
+			return err
+		}
+		if int64(n)+written > cfg.MaxFileSize {
+			return &uploadError{status: http.StatusRequestEntityTooLarge, msg: fmt.Sprintf("file %q exceeds %d bytes", part.FileName(), cfg.MaxFileSize)}
+		}
+	}
+}
+
+func allowedUploadType(patterns []string, contentType string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	for _, pattern := range patterns {
+		if matchStr(pattern, mediaType) {
+			return true
+		}
+	}
+	return false
+}